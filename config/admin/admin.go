@@ -1,8 +1,12 @@
 package admin
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/qor/action_bar"
 	"github.com/qor/admin"
 	"github.com/qor/help"
@@ -16,19 +20,25 @@ import (
 	"github.com/qor/qor"
 	"github.com/qor/qor/resource"
 	"github.com/qor/qor/utils"
+	"github.com/qor/roles"
+	"github.com/qor/transition"
 	"github.com/qor/validations"
 	"github.com/qor/widget"
+	"github.com/qor/worker"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/cryptix/synchrotron/config/admin/bindatafs"
 	"github.com/cryptix/synchrotron/config/auth"
 	"github.com/cryptix/synchrotron/config/i18n"
 	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/mirror"
 	"github.com/cryptix/synchrotron/models"
+	"github.com/cryptix/synchrotron/webhooks"
 )
 
 var Admin *admin.Admin
 var ActionBar *action_bar.ActionBar
+var Worker *worker.Worker
 
 func init() {
 	Admin = admin.New(&admin.AdminConfig{
@@ -133,6 +143,192 @@ func init() {
 			Collection: repoTypes,
 		},
 	})
+	repo.Meta(&admin.Meta{Name: "PollInterval", Label: "Poll Interval (minutes)"})
+	repo.Meta(&admin.Meta{Name: "Visibility", Config: &admin.SelectOneConfig{Collection: models.Visibilities}})
+	repo.Filter(&admin.Filter{
+		Name:   "State",
+		Config: &admin.SelectOneConfig{Collection: models.RepoStates},
+	})
+	repo.IndexAttrs("ID", "Name", "FullName", "URL", "Type", "Visibility", "State", "LastFetchedAt", "NextPollAt", "FailureCount")
+	repo.Scope(&admin.Scope{
+		Name:    "Failing",
+		Handler: func(db *gorm.DB, ctx *qor.Context) *gorm.DB { return db.Where("failure_count > 0") },
+	})
+	repo.Scope(&admin.Scope{
+		Name:    "Discovered",
+		Handler: func(db *gorm.DB, ctx *qor.Context) *gorm.DB { return db.Where("discovered_by_id > 0") },
+	})
+	// filled in by the fetcher and the poller
+	fetcherAttrs := []interface{}{"-FullName", "-Heads", "-Tags", "-LastFetchedAt", "-NextPollAt", "-FailureCount", "-LastError", "-NextRetryAt", "-DiscoveredByID", "-DiscoveryDepth"}
+	repo.NewAttrs(fetcherAttrs...)
+	repo.EditAttrs(fetcherAttrs...)
+	repo.Action(&admin.Action{
+		Name: "Fetch Now",
+		Handler: func(argument *admin.ActionArgument) error {
+			for _, record := range argument.FindSelectedRecords() {
+				if err := EnqueueFetch(record.(*models.Repository).ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Modes: []string{"batch", "show", "menu_item"},
+	})
+	for _, event := range []string{"archive", "unarchive"} {
+		event := event
+		repo.Action(&admin.Action{
+			Name: strings.Title(event),
+			Handler: func(argument *admin.ActionArgument) error {
+				tx := argument.Context.GetDB()
+				for _, record := range argument.FindSelectedRecords() {
+					if err := record.(*models.Repository).TriggerState(tx, event); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Visible: func(record interface{}, context *admin.Context) bool {
+				r, ok := record.(*models.Repository)
+				return ok && (r.State == models.RepoArchived) == (event == "unarchive")
+			},
+			Modes: []string{"batch", "show", "menu_item"},
+		})
+	}
+
+	stateChanges := Admin.AddResource(&transition.StateChangeLog{}, &admin.Config{
+		Name: "State Changes",
+		Menu: []string{"Repositories"},
+	})
+	stateChanges.IndexAttrs("ID", "CreatedAt", "ReferID", "From", "To", "Note")
+	stateChanges.Meta(&admin.Meta{Name: "ReferID", Label: "Repository ID"})
+	stateChanges.Scope(&admin.Scope{
+		Default: true,
+		Handler: func(db *gorm.DB, ctx *qor.Context) *gorm.DB {
+			return db.Where("refer_table = ?", "repositories").Order("id desc")
+		},
+	})
+
+	target := Admin.AddResource(&models.Target{}, &admin.Config{Menu: []string{"Repositories"}})
+	target.Meta(&admin.Meta{Name: "Kind", Config: &admin.SelectOneConfig{
+		Collection: func(_ interface{}, _ *qor.Context) [][]string {
+			var kinds [][]string
+			for _, k := range mirror.TargetKinds() {
+				kinds = append(kinds, []string{k, k})
+			}
+			return kinds
+		},
+	}})
+	target.Meta(&admin.Meta{Name: "Options", Type: "text"})
+	target.Meta(&admin.Meta{Name: "Status", Valuer: func(record interface{}, _ *qor.Context) interface{} {
+		mt, err := mirror.OpenTarget(*record.(*models.Target))
+		if err != nil {
+			return err.Error()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		status, err := mt.Status(ctx)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return status
+	}})
+	// Status dials the target, the index would do that for every row
+	target.IndexAttrs("ID", "Name", "Kind", "Address", "Disabled")
+	target.ShowAttrs("Name", "Kind", "Address", "Options", "Disabled", "Status")
+	target.NewAttrs("Name", "Kind", "Address", "Options", "Disabled")
+	target.EditAttrs(target.NewAttrs())
+	repo.Meta(&admin.Meta{Name: "Targets", Config: &admin.SelectManyConfig{RemoteDataResource: target}})
+
+	refUpdates := Admin.AddResource(&models.RefUpdate{}, &admin.Config{
+		Name:       "Ref History",
+		Menu:       []string{"Repositories"},
+		Permission: roles.Deny(roles.Create, roles.Anyone).Deny(roles.Update, roles.Anyone).Deny(roles.Delete, roles.Anyone),
+	})
+	refUpdates.IndexAttrs("ID", "CreatedAt", "Repository", "Ref", "OldHash", "NewHash", "JobID")
+	refUpdates.Filter(&admin.Filter{
+		Name:   "Repository",
+		Config: &admin.SelectOneConfig{RemoteDataResource: repo},
+	})
+	refUpdates.Filter(&admin.Filter{Name: "Ref", Type: "string"})
+	refUpdates.Scope(&admin.Scope{
+		Default: true,
+		Handler: func(db *gorm.DB, ctx *qor.Context) *gorm.DB { return db.Order("id desc") },
+	})
+
+	hookDeliveries := Admin.AddResource(&models.HookDelivery{}, &admin.Config{
+		Name:       "Webhook Deliveries",
+		Menu:       []string{"Repositories"},
+		Permission: roles.Deny(roles.Create, roles.Anyone).Deny(roles.Update, roles.Anyone),
+	})
+	hookDeliveries.IndexAttrs("ID", "CreatedAt", "Provider", "Event", "Repository", "StatusCode", "Result")
+	hookDeliveries.Meta(&admin.Meta{Name: "Payload", Type: "text"})
+	hookDeliveries.Filter(&admin.Filter{
+		Name:   "Repository",
+		Config: &admin.SelectOneConfig{RemoteDataResource: repo},
+	})
+	hookDeliveries.Filter(&admin.Filter{
+		Name:   "Provider",
+		Config: &admin.SelectOneConfig{Collection: []string{"github", "gitlab", "gitea"}},
+	})
+	hookDeliveries.Scope(&admin.Scope{
+		Default: true,
+		Handler: func(db *gorm.DB, ctx *qor.Context) *gorm.DB { return db.Order("id desc") },
+	})
+
+	webhook := Admin.AddResource(&models.Webhook{}, &admin.Config{Menu: []string{"Repositories"}})
+	webhook.Meta(&admin.Meta{Name: "Secret",
+		Type:   "password",
+		Valuer: func(interface{}, *qor.Context) interface{} { return "" },
+		// the form is always empty, keep the secret unless a new one is entered
+		Setter: func(resource interface{}, metaValue *resource.MetaValue, context *qor.Context) {
+			if secret := utils.ToString(metaValue.Value); secret != "" {
+				resource.(*models.Webhook).Secret = secret
+			}
+		},
+	})
+	webhook.Meta(&admin.Meta{Name: "Repositories", Config: &admin.SelectManyConfig{RemoteDataResource: repo}})
+	webhook.Meta(&admin.Meta{
+		Name:  "Events",
+		Label: "Events (" + strings.Join(models.WebhookEvents, ", ") + " or branch, tag; empty for all)",
+	})
+	webhook.IndexAttrs("ID", "Name", "URL", "Events", "Disabled")
+	webhook.NewAttrs("Name", "URL", "Secret", "Repositories", "Events", "Disabled")
+	webhook.EditAttrs(webhook.NewAttrs())
+	webhook.ShowAttrs("Name", "URL", "Repositories", "Events", "Disabled")
+
+	webhookDeliveries := Admin.AddResource(&models.WebhookDelivery{}, &admin.Config{
+		Name:       "Outgoing Deliveries",
+		Menu:       []string{"Repositories"},
+		Permission: roles.Deny(roles.Create, roles.Anyone).Deny(roles.Update, roles.Anyone),
+	})
+	webhookDeliveries.IndexAttrs("ID", "CreatedAt", "Webhook", "Repository", "State", "Attempts", "StatusCode", "NextAttemptAt", "LastError")
+	webhookDeliveries.Meta(&admin.Meta{Name: "Payload", Type: "text"})
+	webhookDeliveries.Filter(&admin.Filter{
+		Name:   "Webhook",
+		Config: &admin.SelectOneConfig{RemoteDataResource: webhook},
+	})
+	webhookDeliveries.Filter(&admin.Filter{
+		Name:   "State",
+		Config: &admin.SelectOneConfig{Collection: models.DeliveryStates},
+	})
+	webhookDeliveries.Scope(&admin.Scope{
+		Default: true,
+		Handler: func(db *gorm.DB, ctx *qor.Context) *gorm.DB { return db.Order("id desc") },
+	})
+	webhookDeliveries.Action(&admin.Action{
+		Name: "Redeliver",
+		Handler: func(argument *admin.ActionArgument) error {
+			tx := argument.Context.GetDB()
+			for _, record := range argument.FindSelectedRecords() {
+				if err := webhooks.Redeliver(tx, record.(*models.WebhookDelivery)); err != nil {
+					return err
+				}
+			}
+			sender.Wake()
+			return nil
+		},
+		Modes: []string{"batch", "show", "menu_item"},
+	})
 
 	// Blog Management
 	article := Admin.AddResource(&models.Article{}, &admin.Config{Menu: []string{"Blog Management"}})
@@ -142,7 +338,7 @@ func init() {
 	Admin.AddResource(i18n.I18n, &admin.Config{Menu: []string{"Site Management"}, Priority: 1})
 
 	// Add Worker
-	Worker := getWorker()
+	Worker = getWorker()
 	exchange_actions.RegisterExchangeJobs(i18n.I18n, Worker)
 	Admin.AddResource(Worker, &admin.Config{Menu: []string{"Site Management"}})
 
@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/qor/roles"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+const (
+	bundleStamp = "20060102-150405"
+	fullSuffix  = "-full.bundle"
+)
+
+func init() {
+	mirror.RegisterHook("bundles", writeBundles)
+}
+
+// BundleDir is where the bundles of repo are kept inside the Filebox, below its host like the mirror
+func BundleDir(repo *models.Repository) string {
+	return path.Join("bundles", repo.QualifiedName())
+}
+
+// Bundle is a file in the BundleDir of a repository
+type Bundle struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Full bundles can be cloned from, the others need the refs of the bundle before them
+	Full bool
+}
+
+// ListBundles returns the bundles of repo, newest first
+func ListBundles(repo *models.Repository) ([]Bundle, error) {
+	fis, err := ioutil.ReadDir(Filebox.AccessDir(BundleDir(repo)).DirPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var bs []Bundle
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".bundle") {
+			continue
+		}
+		bs = append(bs, Bundle{
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			Full:    strings.HasSuffix(fi.Name(), fullSuffix),
+		})
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].Name > bs[j].Name })
+	return bs, nil
+}
+
+// bundlePermission mirrors the visibility of repo for downloads
+func bundlePermission(repo *models.Repository) *roles.Permission {
+	switch repo.Visibility {
+	case models.VisibilityPublic:
+		return roles.Allow(roles.Read, roles.Anyone)
+	case models.VisibilityPrivate:
+		return roles.Allow(roles.Read, "maintainer")
+	default:
+		return roles.Allow(roles.Read, "member")
+	}
+}
+
+// writeBundles adds an incremental bundle for the refs that moved and a full one when the last is too old.
+// A new full bundle replaces everything before it.
+func writeBundles(ctx context.Context, tx *gorm.DB, repo *models.Repository, res *mirror.Result) error {
+	dir := Filebox.AccessDir(BundleDir(repo))
+	if err := dir.SetPermission(bundlePermission(repo)); err != nil {
+		return errors.Wrap(err, "setting permission failed")
+	}
+	dirPath, err := filepath.Abs(dir.DirPath)
+	if err != nil {
+		return err
+	}
+
+	existing, err := ListBundles(repo)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	stamp := now.Format(bundleStamp)
+
+	// a fresh clone is covered by the full bundle below
+	if len(res.Updates) > 0 && !res.Cloned {
+		err := mirror.Default.IncrementalBundle(ctx, repo, res, filepath.Join(dirPath, stamp+".bundle"))
+		if err != nil && err != mirror.ErrEmptyBundle {
+			return errors.Wrap(err, "incremental bundle failed")
+		}
+	}
+
+	every := time.Duration(config.Config.Bundle.FullInterval) * time.Hour
+	for _, b := range existing {
+		if b.Full && now.Sub(b.ModTime) < every {
+			return nil
+		}
+	}
+	if err := mirror.Default.FullBundle(ctx, repo, filepath.Join(dirPath, stamp+fullSuffix)); err != nil {
+		if err == mirror.ErrEmptyBundle {
+			return nil
+		}
+		return errors.Wrap(err, "full bundle failed")
+	}
+	for _, b := range existing {
+		if b.Name < stamp {
+			os.Remove(filepath.Join(dirPath, b.Name))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/deps"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// depResolver is shared between fetches so go-get lookups are only done once
+var depResolver = &deps.Resolver{Client: &http.Client{Timeout: 30 * time.Second}}
+
+func init() {
+	mirror.RegisterHook("dependencies", discoverDependencies)
+}
+
+// discoverDependencies reads the dependency lists at the default branch of repo
+// and adds a mirror for every upstream that doesn't have one yet.
+func discoverDependencies(ctx context.Context, tx *gorm.DB, repo *models.Repository, res *mirror.Result) error {
+	if len(res.Updates) == 0 || repo.DiscoveryDepth >= config.Config.Deps.Depth {
+		return nil
+	}
+
+	var list []deps.Dependency
+	for _, name := range deps.Files {
+		data, err := mirror.Default.ReadFile(ctx, repo, "HEAD", name)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		ds, err := deps.Parse(name, data)
+		if err != nil {
+			return err
+		}
+		list = append(list, ds...)
+	}
+
+	var (
+		seen   = make(map[string]bool)
+		failed []string
+	)
+	for _, d := range list {
+		if seen[d.Path] {
+			continue
+		}
+		seen[d.Path] = true
+		if !allowLookup(d) {
+			continue
+		}
+
+		up, err := resolveDependency(ctx, d)
+		if err != nil {
+			failed = append(failed, d.Path)
+			continue
+		}
+		if seen[up.URL] || !allowUpstream(up) {
+			continue
+		}
+		seen[up.URL] = true
+
+		existing, err := findMirror(tx, up)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		if err := addDependency(tx, repo, up); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("could not resolve %d dependencies: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// resolveDependency picks the repository for d, its source wins over the import path
+func resolveDependency(ctx context.Context, d deps.Dependency) (deps.Repo, error) {
+	switch {
+	case isSourceURL(d.Source):
+		return deps.Repo{Root: d.Path, URL: d.Source}, nil
+	case d.Source != "":
+		return depResolver.Resolve(ctx, d.Source)
+	}
+	return depResolver.Resolve(ctx, d.Path)
+}
+
+func isSourceURL(source string) bool {
+	return strings.Contains(source, "://") || strings.HasPrefix(source, "git@")
+}
+
+// allowLookup checks what d names against the allow and deny lists before resolveDependency,
+// resolving an import path already sends a request to the host in it.
+func allowLookup(d deps.Dependency) bool {
+	if !allowDependency(d.Path) {
+		return false
+	}
+	switch {
+	case isSourceURL(d.Source):
+		return allowURL(d.Source)
+	case d.Source != "":
+		return allowDependency(d.Source)
+	}
+	return true
+}
+
+// allowUpstream checks the import path of up and the host and path of its URL against the allow and deny lists
+func allowUpstream(up deps.Repo) bool {
+	return allowDependency(up.Root) && allowURL(up.URL)
+}
+
+// allowURL checks the host and path of raw against the allow and deny lists.
+// Only URLs that git fetches over the network pass, a dependency must not point into the local filesystem.
+func allowURL(raw string) bool {
+	host, p := remoteUpstream(raw)
+	if host == "" {
+		return false
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	return allowDependency(host + "/" + p)
+}
+
+// remoteUpstream returns the host and path of a https, ssh (or scp-like) or git:// URL, host is empty for any other URL
+func remoteUpstream(raw string) (host, p string) {
+	if i := strings.Index(raw, "://"); i >= 0 {
+		switch strings.ToLower(raw[:i]) {
+		case "https", "ssh", "git":
+		default:
+			return "", ""
+		}
+	} else if strings.Contains(raw, "::") {
+		// <transport>::<address> runs a remote helper like ext::
+		return "", ""
+	}
+	return models.Repository{URL: raw}.Upstream()
+}
+
+func allowDependency(root string) bool {
+	allow, deny := config.Config.Deps.Allow, config.Config.Deps.Deny
+	return (len(allow) == 0 || deps.Match(allow, root)) && !deps.Match(deny, root)
+}
+
+// findMirror looks for a repository that already mirrors up, by import path or by URL
+func findMirror(tx *gorm.DB, up deps.Repo) (*models.Repository, error) {
+	found, _, err := models.FindRepositoryByPath(tx, up.Root)
+	if err != nil || found != nil {
+		return found, err
+	}
+	return models.FindRepositoryByURL(tx, up.URL)
+}
+
+// addDependency creates the mirror of up and fetches it right away
+func addDependency(tx *gorm.DB, parent *models.Repository, up deps.Repo) error {
+	repoType := "Native"
+	if host, _ := (models.Repository{URL: up.URL}).Upstream(); host == "github.com" {
+		repoType = "Github"
+	}
+	repo := models.Repository{
+		Name:           path.Base(up.Root),
+		URL:            up.URL,
+		Type:           repoType,
+		Visibility:     parent.Visibility,
+		DiscoveredByID: parent.ID,
+		DiscoveryDepth: parent.DiscoveryDepth + 1,
+	}
+	repo.State = models.RepoPending
+	// the poller would pick it up as well, the job below is the first fetch
+	next := time.Now().Add(pollInterval(repo))
+	repo.NextPollAt = &next
+	if err := tx.Create(&repo).Error; err != nil {
+		return errors.Wrapf(err, "adding %s failed", up.Root)
+	}
+	return EnqueueFetch(repo.ID)
+}
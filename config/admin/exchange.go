@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qor/exchange"
+	"github.com/qor/exchange/backends/csv"
+	"github.com/qor/media/oss"
+	"github.com/qor/qor"
+	"github.com/qor/qor/resource"
+	"github.com/qor/qor/utils"
+	"github.com/qor/validations"
+	"github.com/qor/worker"
+
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// RepositoryExchange reads and writes the list of upstreams as CSV, rows are matched by URL
+var RepositoryExchange = exchange.NewResource(&models.Repository{}, exchange.Config{PrimaryField: "URL"})
+
+func init() {
+	RepositoryExchange.Meta(&exchange.Meta{Name: "Name"})
+	RepositoryExchange.Meta(&exchange.Meta{Name: "URL"})
+	RepositoryExchange.Meta(&exchange.Meta{Name: "Type"})
+	RepositoryExchange.Meta(&exchange.Meta{Name: "PollInterval", Header: "Poll Interval"})
+	// targets are referenced by name, separated by commas
+	RepositoryExchange.Meta(&exchange.Meta{
+		Name: "Targets",
+		Valuer: func(record interface{}, ctx *qor.Context) interface{} {
+			var targets []models.Target
+			ctx.GetDB().Model(record).Related(&targets, "Targets")
+			names := make([]string, len(targets))
+			for i, t := range targets {
+				names[i] = t.Name
+			}
+			return strings.Join(names, ",")
+		},
+		// set by the targets processor, it can fail
+		Setter: func(interface{}, *resource.MetaValue, *qor.Context) {},
+	})
+
+	// processors run after the values are decoded, validators before
+	RepositoryExchange.AddProcessor(&resource.Processor{
+		Name: "check_url",
+		Handler: func(record interface{}, _ *resource.MetaValues, _ *qor.Context) error {
+			if repo := record.(*models.Repository); strings.TrimSpace(repo.URL) == "" {
+				return validations.NewError(repo, "URL", "URL can't be blank")
+			}
+			return nil
+		},
+	})
+	RepositoryExchange.AddProcessor(&resource.Processor{
+		Name: "targets",
+		Handler: func(record interface{}, metaValues *resource.MetaValues, ctx *qor.Context) error {
+			mv := metaValues.Get("Targets")
+			if mv == nil {
+				return nil
+			}
+			repo := record.(*models.Repository)
+			var names []string
+			for _, n := range strings.Split(utils.ToString(mv.Value), ",") {
+				if n = strings.TrimSpace(n); n != "" {
+					names = append(names, n)
+				}
+			}
+			var targets []models.Target
+			if len(names) > 0 {
+				if err := ctx.GetDB().Where("name IN (?)", names).Find(&targets).Error; err != nil {
+					return err
+				}
+			}
+			if len(targets) != len(names) {
+				return validations.NewError(repo, "Targets", "unknown target in "+strings.Join(names, ","))
+			}
+			// saving only adds join rows, existing repositories drop the targets that aren't listed anymore
+			if repo.ID != 0 {
+				if err := ctx.GetDB().Model(repo).Association("Targets").Replace(targets).Error; err != nil {
+					return err
+				}
+			}
+			repo.Targets = targets
+			return nil
+		},
+	})
+}
+
+type importRepositoriesArgument struct {
+	File oss.OSS
+}
+
+func registerRepositoryExchangeJobs(w *worker.Worker) {
+	w.RegisterJob(&worker.Job{
+		Name:     "Import Repositories",
+		Group:    "Repositories",
+		Handler:  importRepositories,
+		Resource: Admin.NewResource(&importRepositoriesArgument{}),
+	})
+	w.RegisterJob(&worker.Job{
+		Name:    "Export Repositories",
+		Group:   "Repositories",
+		Handler: exportRepositories,
+	})
+}
+
+// importRepositories creates or updates a repository for every row of the uploaded CSV.
+// Rows with errors are listed in the results, nothing is saved if there are any.
+func importRepositories(argument interface{}, qorJob worker.QorJobInterface) error {
+	arg := argument.(*importRepositoriesArgument)
+	if arg.File.URL() == "" {
+		return fmt.Errorf("import: no file uploaded")
+	}
+	qorJob.AddLog("Importing repositories...")
+
+	var errorCount uint
+	err := RepositoryExchange.Import(
+		csv.New(filepath.Join("public", arg.File.URL()), csv.Config{TrimSpace: true}),
+		&qor.Context{DB: db.DB},
+		func(progress exchange.Progress) error {
+			cells := []worker.TableCell{{Value: fmt.Sprint(progress.Current)}}
+			var hasError bool
+			for _, cell := range progress.Cells {
+				tableCell := worker.TableCell{Value: fmt.Sprint(cell.Value)}
+				if cell.Error != nil {
+					hasError = true
+					tableCell.Error = cell.Error.Error()
+				}
+				cells = append(cells, tableCell)
+			}
+			if hasError || progress.Errors.HasError() {
+				errorCount++
+				if errorCount == 1 {
+					header := []worker.TableCell{{Value: "Line No."}}
+					for _, cell := range progress.Cells {
+						header = append(header, worker.TableCell{Value: cell.Header})
+					}
+					qorJob.AddResultsRow(header...)
+				}
+				qorJob.AddResultsRow(cells...)
+				qorJob.AddLog(fmt.Sprintf("%d/%d failed: %s", progress.Current, progress.Total, progress.Errors.Error()))
+			} else if repo, ok := progress.Value.(*models.Repository); ok {
+				qorJob.AddLog(fmt.Sprintf("%d/%d %s", progress.Current, progress.Total, repo.URL))
+			}
+			if progress.Total > 0 {
+				qorJob.SetProgress(uint(float32(progress.Current) / float32(progress.Total) * 100))
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("import: %d rows failed, nothing was imported", errorCount)
+	}
+	qorJob.AddLog("Imported repositories")
+	return nil
+}
+
+// exportRepositories writes every repository into a CSV below /downloads that can be imported again
+func exportRepositories(argument interface{}, qorJob worker.QorJobInterface) error {
+	qorJob.AddLog("Exporting repositories...")
+	fileName := fmt.Sprintf("/downloads/repositories.%v.csv", time.Now().UnixNano())
+	fullName := filepath.Join("public", fileName)
+	if err := os.MkdirAll(filepath.Dir(fullName), os.ModePerm); err != nil {
+		return err
+	}
+	err := RepositoryExchange.Export(
+		csv.New(fullName),
+		&qor.Context{DB: db.DB},
+		func(progress exchange.Progress) error {
+			qorJob.AddLog(fmt.Sprintf("%d/%d %s", progress.Current, progress.Total, progress.Value.(*models.Repository).URL))
+			if progress.Total > 0 {
+				qorJob.SetProgress(uint(float32(progress.Current) / float32(progress.Total) * 100))
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	qorJob.SetProgressText(fmt.Sprintf("<a href='%v'>Download exported repositories</a>", fileName))
+	return nil
+}
@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qor/admin"
+	"github.com/qor/qor"
+	"github.com/qor/worker"
+
+	"github.com/cryptix/go/backoff"
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+const fetchJobName = "Fetch Repository"
+
+type fetchRepositoryArgument struct {
+	RepositoryID uint
+}
+
+func registerFetchJob(w *worker.Worker) {
+	argRes := Admin.NewResource(&fetchRepositoryArgument{})
+	argRes.Meta(&admin.Meta{Name: "RepositoryID", Label: "Repository", Config: &admin.SelectOneConfig{
+		Collection: func(_ interface{}, ctx *qor.Context) (options [][]string) {
+			var repos []models.Repository
+			ctx.GetDB().Select("id, name").Order("name").Find(&repos)
+			for _, r := range repos {
+				options = append(options, []string{fmt.Sprint(r.ID), r.Name})
+			}
+			return options
+		},
+	}})
+
+	w.RegisterJob(&worker.Job{
+		Name:     fetchJobName,
+		Group:    "Repositories",
+		Handler:  fetchRepository,
+		Resource: argRes,
+	})
+}
+
+func fetchRepository(argument interface{}, qorJob worker.QorJobInterface) error {
+	arg := argument.(*fetchRepositoryArgument)
+
+	var repo models.Repository
+	if err := db.DB.First(&repo, arg.RepositoryID).Error; err != nil {
+		return fmt.Errorf("fetch: failed to load repository %d: %s", arg.RepositoryID, err)
+	}
+	if repo.State == models.RepoArchived {
+		return fmt.Errorf("fetch: repository %s is archived", repo.Name)
+	}
+	if err := repo.TriggerState(db.DB, "fetch_started", "job "+qorJob.GetJobID()); err != nil {
+		return err
+	}
+
+	qorJob.AddLog(fmt.Sprintf("Fetching %s from %s", repo.Name, repo.URL))
+	qorJob.SetProgress(10)
+
+	ctx := mirror.WithJobID(JobQueue.Context(qorJob), qorJob.GetJobID())
+	res, err := mirror.Default.Sync(ctx, db.DB, &repo)
+	now := time.Now()
+	if err != nil {
+		qorJob.AddLog(err.Error())
+		// back off from upstreams that keep failing
+		failures := repo.FailureCount + 1
+		retry := now.Add(retryPolicy.Duration(int(repo.FailureCount)))
+		// without the failure count the next poll wouldn't back off
+		if dbErr := db.DB.Model(&repo).UpdateColumns(map[string]interface{}{
+			"last_fetched_at": now,
+			"failure_count":   failures,
+			"last_error":      truncate(err.Error(), 1024),
+			"next_retry_at":   retry,
+			"next_poll_at":    retry,
+		}).Error; dbErr != nil {
+			qorJob.AddLog("Saving the failure failed: " + dbErr.Error())
+		}
+		qorJob.AddLog(fmt.Sprintf("Failed %d times in a row, retrying at %s", failures, retry.Format(time.RFC3339)))
+		if stateErr := repo.TriggerState(db.DB, "fetch_failed", err.Error()); stateErr != nil {
+			qorJob.AddLog("Marking the repository as failing failed: " + stateErr.Error())
+		}
+		return err
+	}
+	next := now.Add(pollInterval(repo))
+	if err := db.DB.Model(&repo).UpdateColumns(map[string]interface{}{
+		"last_fetched_at": now,
+		"failure_count":   0,
+		"last_error":      "",
+		"next_retry_at":   nil,
+		"next_poll_at":    next,
+	}).Error; err != nil {
+		qorJob.AddLog("Saving the fetch time failed: " + err.Error())
+	}
+	if err := repo.TriggerState(db.DB, "fetch_succeeded", fmt.Sprintf("job %s: %d refs updated", qorJob.GetJobID(), len(res.Updates))); err != nil {
+		return err
+	}
+
+	for _, err := range mirror.RunHooks(ctx, db.DB, &repo, res) {
+		qorJob.AddLog(err.Error())
+	}
+
+	if res.Cloned {
+		qorJob.AddLog("Cloned into " + mirror.Default.Path(&repo))
+	}
+	for _, u := range res.Updates {
+		qorJob.AddLog(fmt.Sprintf("%s: %s -> %s", u.Name, short(u.Old), short(u.New)))
+	}
+	qorJob.AddLog(fmt.Sprintf("Done, %d refs updated. Next poll at %s", len(res.Updates), next.Format(time.RFC3339)))
+	return nil
+}
+
+// retryPolicy doubles the wait after every failed fetch, from one minute up to about eight hours
+var retryPolicy = func() backoff.IncreasePolicy {
+	var p backoff.IncreasePolicy
+	for i := uint(0); i < 10; i++ {
+		p.Millis = append(p.Millis, int(time.Minute/time.Millisecond)<<i)
+	}
+	return p
+}()
+
+// pollInterval is the time to wait between fetches of repo
+func pollInterval(repo models.Repository) time.Duration {
+	minutes := repo.PollInterval
+	if minutes == 0 {
+		minutes = config.Config.Poll.Interval
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func short(hash string) string {
+	if hash == "" {
+		return "(none)"
+	}
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
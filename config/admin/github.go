@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/qor/admin"
+	"github.com/qor/worker"
+	"golang.org/x/oauth2"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/deps"
+	"github.com/cryptix/synchrotron/models"
+)
+
+const githubImportJobName = "Import from GitHub"
+
+// what the GitHub import lists
+const (
+	githubStarred = "Starred"
+	githubOwned   = "Owned"
+	githubOrg     = "Organization"
+)
+
+type githubImportArgument struct {
+	Source string
+	// Name is the user or organization, the owner of config.Config.GitHubAPI.Token if empty.
+	// There is no token field, job arguments are stored with the job.
+	Name string
+	// Visibility of the new repositories, private ones on GitHub stay private
+	Visibility string
+}
+
+func registerGitHubImportJob(w *worker.Worker) {
+	argRes := Admin.NewResource(&githubImportArgument{})
+	argRes.Meta(&admin.Meta{Name: "Source", Config: &admin.SelectOneConfig{Collection: []string{githubStarred, githubOwned, githubOrg}}})
+	argRes.Meta(&admin.Meta{Name: "Name", Label: "User or Organization"})
+	argRes.Meta(&admin.Meta{Name: "Visibility", Config: &admin.SelectOneConfig{Collection: models.Visibilities}})
+
+	w.RegisterJob(&worker.Job{
+		Name:     githubImportJobName,
+		Group:    "Repositories",
+		Handler:  importFromGitHub,
+		Resource: argRes,
+	})
+}
+
+// NewGitHubClient talks to config.Config.GitHubAPI, as the owner of token if it's not empty
+func NewGitHubClient(ctx context.Context, token string) (*github.Client, error) {
+	var hc *http.Client
+	if token != "" {
+		hc = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	}
+	return config.GitHubClient(hc)
+}
+
+// importFromGitHub adds a repository for everything the chosen list has that isn't mirrored yet.
+// The poller fetches them, like any other new repository.
+func importFromGitHub(argument interface{}, qorJob worker.QorJobInterface) error {
+	arg := argument.(*githubImportArgument)
+	if arg.Name == "" && arg.Source == githubOrg {
+		return fmt.Errorf("github: the organization is missing")
+	}
+	token := config.Config.GitHubAPI.Token
+	if arg.Name == "" && token == "" {
+		return fmt.Errorf("github: either a user or the githubapi token is needed")
+	}
+	visibility := arg.Visibility
+	if visibility == "" {
+		visibility = models.VisibilityInternal
+	}
+
+	ctx := JobQueue.Context(qorJob)
+	client, err := NewGitHubClient(ctx, token)
+	if err != nil {
+		return err
+	}
+	qorJob.AddLog(fmt.Sprintf("Listing %s repositories of %s from %s", strings.ToLower(arg.Source), nameOrSelf(arg.Name), client.BaseURL))
+
+	var added, existing int
+	err = listGitHubRepos(ctx, client, arg.Source, arg.Name, func(gr *github.Repository) error {
+		repo := models.Repository{
+			Name:       gr.GetName(),
+			URL:        gr.GetCloneURL(),
+			Type:       "Github",
+			Visibility: visibility,
+		}
+		if gr.GetPrivate() {
+			repo.Visibility = models.VisibilityPrivate
+		}
+		host, _ := repo.Upstream()
+		found, err := findMirror(db.DB, deps.Repo{Root: host + "/" + gr.GetFullName(), URL: repo.URL})
+		if err != nil {
+			return err
+		}
+		if found != nil {
+			existing++
+			return nil
+		}
+		repo.State = models.RepoPending
+		if err := db.DB.Create(&repo).Error; err != nil {
+			return fmt.Errorf("github: adding %s failed: %s", gr.GetFullName(), err)
+		}
+		added++
+		qorJob.AddLog("Added " + gr.GetFullName())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	qorJob.AddLog(fmt.Sprintf("Done, %d repositories added, %d were mirrored already", added, existing))
+	return nil
+}
+
+// listGitHubRepos calls fn for every repository of the list, page by page
+func listGitHubRepos(ctx context.Context, client *github.Client, source, name string, fn func(*github.Repository) error) error {
+	page := 1
+	for page != 0 {
+		opt := github.ListOptions{Page: page, PerPage: 100}
+		var (
+			repos []*github.Repository
+			resp  *github.Response
+			err   error
+		)
+		switch source {
+		case githubStarred:
+			var starred []*github.StarredRepository
+			starred, resp, err = client.Activity.ListStarred(ctx, name, &github.ActivityListStarredOptions{ListOptions: opt})
+			for _, s := range starred {
+				repos = append(repos, s.Repository)
+			}
+		case githubOwned:
+			repos, resp, err = client.Repositories.List(ctx, name, &github.RepositoryListOptions{Type: "owner", ListOptions: opt})
+		case githubOrg:
+			repos, resp, err = client.Repositories.ListByOrg(ctx, name, &github.RepositoryListByOrgOptions{Type: "all", ListOptions: opt})
+		default:
+			return fmt.Errorf("github: unknown source %q", source)
+		}
+		if err != nil {
+			return fmt.Errorf("github: listing repositories failed: %s", err)
+		}
+		for _, r := range repos {
+			if r == nil || r.GetCloneURL() == "" {
+				continue
+			}
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		page = resp.NextPage
+	}
+	return nil
+}
+
+func nameOrSelf(name string) string {
+	if name == "" {
+		return "the token owner"
+	}
+	return name
+}
@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/qor/worker"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// testJob collects the log of a job handler that runs outside of the worker
+type testJob struct {
+	worker.QorJobInterface
+	logs []string
+}
+
+func (j *testJob) GetJobID() string { return "test" }
+
+func (j *testJob) AddLog(s string) error {
+	j.logs = append(j.logs, s)
+	return nil
+}
+
+func TestImportFromGitHub(t *testing.T) {
+	// the repositories of the organization acme, one page per element
+	pages := [][]*github.Repository{
+		{
+			{Name: github.String("public"), FullName: github.String("acme/public"), CloneURL: github.String("https://github.com/acme/public.git")},
+			{Name: github.String("secret"), FullName: github.String("acme/secret"), CloneURL: github.String("https://github.com/acme/secret.git"), Private: github.Bool(true)},
+		},
+		{
+			{Name: github.String("mirrored"), FullName: github.String("acme/mirrored"), CloneURL: github.String("https://github.com/acme/mirrored.git")},
+			{Name: github.String("last"), FullName: github.String("acme/last"), CloneURL: github.String("https://github.com/acme/last.git")},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/orgs/acme/repos" {
+			http.NotFound(w, req)
+			return
+		}
+		page := 1
+		fmt.Sscan(req.URL.Query().Get("page"), &page)
+		if page < 1 || page > len(pages) {
+			json.NewEncoder(w).Encode([]*github.Repository{})
+			return
+		}
+		if page < len(pages) {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=%d>; rel="next"`, req.Host, req.URL.Path, page+1))
+		}
+		json.NewEncoder(w).Encode(pages[page-1])
+	}))
+	defer srv.Close()
+	config.Config.GitHubAPI.BaseURL = srv.URL
+	config.Config.GitHubAPI.Token = ""
+
+	if err := db.DB.DropTableIfExists(&models.Repository{}).AutoMigrate(&models.Repository{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	existing := models.Repository{Name: "mirrored", URL: "https://github.com/acme/mirrored.git", Visibility: models.VisibilityInternal}
+	if err := db.DB.Create(&existing).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	job := &testJob{}
+	arg := &githubImportArgument{Source: githubOrg, Name: "acme", Visibility: models.VisibilityPublic}
+	if err := importFromGitHub(arg, job); err != nil {
+		t.Fatalf("import failed: %v\n%s", err, strings.Join(job.logs, "\n"))
+	}
+
+	var repos []models.Repository
+	if err := db.DB.Order("id").Find(&repos).Error; err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]models.Repository)
+	for _, r := range repos {
+		got[r.FullName] = r
+	}
+	if len(repos) != 4 {
+		t.Errorf("%d repositories after the import, want 4", len(repos))
+	}
+	for name, visibility := range map[string]string{
+		"acme/public":   models.VisibilityPublic,
+		"acme/secret":   models.VisibilityPrivate,
+		"acme/mirrored": models.VisibilityInternal,
+		"acme/last":     models.VisibilityPublic,
+	} {
+		r, ok := got[name]
+		if !ok {
+			t.Errorf("%s wasn't imported", name)
+			continue
+		}
+		if r.Visibility != visibility {
+			t.Errorf("%s is %s, want %s", name, r.Visibility, visibility)
+		}
+	}
+	if r := got["acme/mirrored"]; r.ID != existing.ID {
+		t.Errorf("acme/mirrored was added again as %d", r.ID)
+	}
+	if last := job.logs[len(job.logs)-1]; last != "Done, 3 repositories added, 1 were mirrored already" {
+		t.Errorf("last log line is %q", last)
+	}
+}
@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"context"
+	"time"
+
+	"github.com/qor/worker"
+
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// defaultPollTick is used when config.Config.Poll.Tick is 0, time.NewTicker panics on it
+const defaultPollTick = 60 * time.Second
+
+// StartPoller enqueues a fetch job for every repository whose poll interval ran out.
+// It checks every config.Config.Poll.Tick seconds until ctx is canceled.
+func StartPoller(ctx context.Context, log logging.Interface) {
+	every := time.Duration(config.Config.Poll.Tick) * time.Second
+	if every <= 0 {
+		every = defaultPollTick
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		if err := pollDue(log); err != nil {
+			log.Log("event", "poll failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func pollDue(log logging.Interface) error {
+	now := time.Now()
+	var due []models.Repository
+	err := db.DB.Where("next_poll_at IS NULL OR next_poll_at <= ?", now).
+		Where("state IS NULL OR state <> ?", models.RepoArchived).
+		Find(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, repo := range due {
+		if repo.State == models.RepoSynced {
+			if err := repo.TriggerState(db.DB, "mark_stale"); err != nil {
+				return err
+			}
+		}
+		// push the next poll out so the repository isn't enqueued twice while the job is pending
+		next := now.Add(pollInterval(repo))
+		if err := db.DB.Model(&repo).UpdateColumn("next_poll_at", next).Error; err != nil {
+			return err
+		}
+		if err := EnqueueFetch(repo.ID); err != nil {
+			log.Log("event", "enqueue failed", "repo", repo.Name, "err", err)
+			continue
+		}
+		log.Log("event", "fetch enqueued", "repo", repo.Name)
+	}
+	return nil
+}
+
+// EnqueueFetch adds a fetch job for the repository with id to the worker queue
+func EnqueueFetch(id uint) error {
+	qorJob := Worker.GetRegisteredJob(fetchJobName).NewStruct().(worker.QorJobInterface)
+	qorJob.SetSerializableArgumentValue(&fetchRepositoryArgument{RepositoryID: id})
+	if err := Worker.JobResource.CallSave(qorJob, Admin.NewContext(nil, nil).Context); err != nil {
+		return err
+	}
+	return Worker.AddJob(qorJob)
+}
@@ -0,0 +1,248 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/qor/worker"
+
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/db"
+)
+
+// DBQueue runs qor jobs on a bounded pool of goroutines.
+// Pending jobs live in the jobs table, so they are picked up again after a restart.
+type DBQueue struct {
+	Worker *worker.Worker
+	Size   int
+
+	log   logging.Interface
+	ctx   context.Context
+	wake  chan struct{}
+	jobs  chan string
+	mu    sync.Mutex
+	taken map[string]bool
+	runs  map[string]*jobRun
+}
+
+type jobRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	killed bool
+}
+
+// NewDBQueue returns a queue running at most size jobs at once
+func NewDBQueue(size int) *DBQueue {
+	if size < 1 {
+		size = 1
+	}
+	return &DBQueue{
+		Size:  size,
+		wake:  make(chan struct{}, 1),
+		jobs:  make(chan string),
+		taken: make(map[string]bool),
+		runs:  make(map[string]*jobRun),
+	}
+}
+
+// Start resumes interrupted jobs and runs pending ones until ctx is canceled
+func (q *DBQueue) Start(ctx context.Context, log logging.Interface) {
+	q.ctx, q.log = ctx, log
+
+	// jobs that were running when we went down start over
+	err := db.DB.Model(q.Worker.JobResource.Value).
+		Where("status = ?", worker.JobStatusRunning).
+		UpdateColumn("status", worker.JobStatusNew).Error
+	if err != nil {
+		log.Log("event", "resume failed", "err", err)
+	}
+
+	for i := 0; i < q.Size; i++ {
+		go func() {
+			for id := range q.jobs {
+				q.run(id)
+			}
+		}()
+	}
+	go q.dispatch()
+}
+
+// Add wakes up the dispatcher, the job itself is already stored
+func (q *DBQueue) Add(j worker.QorJobInterface) error {
+	if sched, ok := j.GetArgument().(worker.Scheduler); ok && sched.GetScheduleTime() != nil {
+		if err := j.SetStatus(worker.JobStatusScheduled); err != nil {
+			return err
+		}
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run calls the handler of the job
+func (q *DBQueue) Run(j worker.QorJobInterface) error {
+	job := j.GetJob()
+
+	if job.Handler != nil {
+		return job.Handler(j.GetSerializableArgument(j), j)
+	}
+
+	return errors.New("DBQueue: no handler found for job " + job.Name)
+}
+
+// Kill cancels the context of a running job
+func (q *DBQueue) Kill(j worker.QorJobInterface) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, ok := q.runs[j.GetJobID()]
+	if !ok {
+		return errors.New("DBQueue: job is not running here")
+	}
+	r.killed = true
+	r.cancel()
+	return nil
+}
+
+// Remove dequeues a job that didn't start yet
+func (q *DBQueue) Remove(j worker.QorJobInterface) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.runs[j.GetJobID()]; ok {
+		return errors.New("DBQueue: failed to remove job as it is running")
+	}
+	switch j.GetStatus() {
+	case worker.JobStatusNew, worker.JobStatusScheduled:
+		return j.SetStatus(worker.JobStatusCancelled)
+	}
+	// KillJob already marked it, the dispatcher skips it from now on
+	return nil
+}
+
+// Context returns the context of a running job, which is canceled when the job is killed
+func (q *DBQueue) Context(j worker.QorJobInterface) context.Context {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if r, ok := q.runs[j.GetJobID()]; ok {
+		return r.ctx
+	}
+	// run outside of the pool, through --qor-job
+	return context.Background()
+}
+
+func (q *DBQueue) dispatch() {
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+	for {
+		for _, id := range q.pending() {
+			select {
+			case q.jobs <- id:
+			case <-q.ctx.Done():
+				close(q.jobs)
+				return
+			}
+		}
+		select {
+		case <-q.ctx.Done():
+			close(q.jobs)
+			return
+		case <-q.wake:
+		case <-tick.C:
+		}
+	}
+}
+
+// pending claims the jobs that are due and not taken by a worker yet
+func (q *DBQueue) pending() []string {
+	var rows []struct {
+		ID     uint
+		Status string
+	}
+	err := db.DB.Model(q.Worker.JobResource.Value).
+		Where("status IN (?)", []string{worker.JobStatusNew, worker.JobStatusScheduled}).
+		Order("id").Select("id, status").Scan(&rows).Error
+	if err != nil {
+		q.log.Log("event", "dispatch failed", "err", err)
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ids []string
+	for _, row := range rows {
+		id := fmt.Sprint(row.ID)
+		if q.taken[id] {
+			continue
+		}
+		if row.Status == worker.JobStatusScheduled {
+			j, err := q.Worker.GetJob(id)
+			if err != nil {
+				continue
+			}
+			if sched, ok := j.GetArgument().(worker.Scheduler); ok && sched.GetScheduleTime() != nil {
+				continue
+			}
+		}
+		q.taken[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (q *DBQueue) run(id string) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.taken, id)
+		delete(q.runs, id)
+		q.mu.Unlock()
+	}()
+
+	qorJob, err := q.Worker.GetJob(id)
+	if err != nil {
+		q.log.Log("event", "job load failed", "job", id, "err", err)
+		return
+	}
+	if s := qorJob.GetStatus(); s != worker.JobStatusNew && s != worker.JobStatusScheduled {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	r := &jobRun{ctx: ctx, cancel: cancel}
+	q.mu.Lock()
+	q.runs[id] = r
+	q.mu.Unlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			qorJob.AddLog(string(debug.Stack()))
+			qorJob.SetProgressText(fmt.Sprint(p))
+			qorJob.SetStatus(worker.JobStatusException)
+		}
+	}()
+
+	if err := qorJob.SetStatus(worker.JobStatusRunning); err != nil {
+		q.log.Log("event", "job start failed", "job", id, "err", err)
+		return
+	}
+	err = q.Run(qorJob)
+
+	q.mu.Lock()
+	killed := r.killed
+	q.mu.Unlock()
+	switch {
+	case killed:
+		qorJob.SetStatus(worker.JobStatusKilled)
+	case err == nil:
+		qorJob.SetStatus(worker.JobStatusDone)
+	default:
+		qorJob.SetProgressText(err.Error())
+		qorJob.SetStatus(worker.JobStatusException)
+	}
+	q.log.Log("event", "job finished", "job", id, "name", qorJob.GetJobName(), "status", qorJob.GetStatus())
+}
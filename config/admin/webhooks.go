@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/cryptix/go/backoff"
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+	"github.com/cryptix/synchrotron/webhooks"
+)
+
+// deliveryPolicy doubles the wait after every failed delivery, from 30 seconds up to about four hours
+var deliveryPolicy = func() backoff.IncreasePolicy {
+	var p backoff.IncreasePolicy
+	for i := uint(0); i < 10; i++ {
+		p.Millis = append(p.Millis, int(30*time.Second/time.Millisecond)<<i)
+	}
+	return p
+}()
+
+var sender = webhooks.NewSender(
+	config.Config.Webhooks.MaxAttempts,
+	deliveryPolicy,
+	time.Duration(config.Config.Webhooks.Tick)*time.Second,
+	time.Duration(config.Config.Webhooks.Timeout)*time.Second,
+)
+
+func init() {
+	mirror.RegisterHook("webhooks", queueWebhooks)
+}
+
+// queueWebhooks adds a delivery for every subscriber of the refs the fetch moved
+func queueWebhooks(ctx context.Context, tx *gorm.DB, repo *models.Repository, res *mirror.Result) error {
+	n, err := webhooks.Queue(tx, repo, res.Updates, mirror.JobIDFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		sender.Wake()
+	}
+	return nil
+}
+
+// StartWebhookSender posts queued webhook deliveries until ctx is canceled
+func StartWebhookSender(ctx context.Context, log logging.Interface) {
+	sender.DB = db.DB
+	sender.Log = log
+	sender.Run(ctx)
+}
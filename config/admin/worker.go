@@ -1,46 +1,23 @@
 package admin
 
 import (
-	"errors"
 	"fmt"
 	"time"
 
-	"github.com/qor/media/oss"
 	"github.com/qor/worker"
-)
-
-type SimpleQueue struct {
-	Worker *worker.Worker
-}
-
-func (q *SimpleQueue) Add(j worker.QorJobInterface) error {
-	return q.Worker.RunJob(j.GetJobID())
-}
-
-func (q *SimpleQueue) Run(j worker.QorJobInterface) error {
-	job := j.GetJob()
-
-	if job.Handler != nil {
-		return job.Handler(j.GetSerializableArgument(j), j)
-	}
-
-	return errors.New("SimpleQue:no handler found for job " + job.Name)
-}
 
-func (q *SimpleQueue) Kill(j worker.QorJobInterface) error {
-	return errors.New("SimpleQue:kill not implemented")
-}
+	"github.com/cryptix/synchrotron/config"
+)
 
-func (q *SimpleQueue) Remove(j worker.QorJobInterface) error {
-	return errors.New("SimpleQue:remove not implemented")
-}
+// JobQueue runs the jobs of Worker
+var JobQueue *DBQueue
 
 func getWorker() *worker.Worker {
-	sq := SimpleQueue{}
+	JobQueue = NewDBQueue(config.Config.Worker.Concurrency)
 	Worker := worker.New(&worker.Config{
-		Queue: &sq,
+		Queue: JobQueue,
 	})
-	sq.Worker = Worker
+	JobQueue.Worker = Worker
 
 	type sendNewsletterArgument struct {
 		Subject      string
@@ -65,9 +42,9 @@ func getWorker() *worker.Worker {
 		Resource: Admin.NewResource(&sendNewsletterArgument{}),
 	})
 
-	type importProductArgument struct {
-		File oss.OSS
-	}
+	registerFetchJob(Worker)
+	registerRepositoryExchangeJobs(Worker)
+	registerGitHubImportJob(Worker)
 
 	return Worker
 }
@@ -14,6 +14,16 @@ func init() {
 	roles.Register("admin", func(req *http.Request, currentUser interface{}) bool {
 		return currentUser != nil && currentUser.(*models.User).Role == "Admin"
 	})
+	roles.Register("maintainer", func(req *http.Request, currentUser interface{}) bool {
+		if currentUser == nil {
+			return false
+		}
+		role := currentUser.(*models.User).Role
+		return role == "Admin" || role == "Maintainer"
+	})
+	roles.Register("member", func(req *http.Request, currentUser interface{}) bool {
+		return currentUser != nil
+	})
 }
 
 type AdminAuth struct{}
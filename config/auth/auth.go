@@ -30,7 +30,9 @@ var (
 )
 
 func init() {
-	//Auth.RegisterProvider(github.New(&config.Config.Github))
+	if config.Config.Github.ClientID != "" {
+		Auth.RegisterProvider(newGitHubProvider())
+	}
 	Auth.RegisterProvider(twitter.New(&twitter.Config{
 		ClientID:     config.Config.TWAK,
 		ClientSecret: config.Config.TWAS,
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	gh "github.com/google/go-github/github"
+	"github.com/qor/auth"
+	"github.com/qor/auth/auth_identity"
+	"github.com/qor/auth/claims"
+	"github.com/qor/auth/providers/github"
+	"github.com/qor/qor/utils"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// newGitHubProvider signs users in with config.Config.Github.
+// Unlike the stock provider it asks config.Config.GitHubAPI about the user and updates their role on every sign in.
+func newGitHubProvider() *github.GithubProvider {
+	cfg := config.Config.Github
+	if len(cfg.Scopes) == 0 {
+		// needed to see private organization and team memberships
+		cfg.Scopes = []string{"read:org"}
+	}
+	provider := github.New(&cfg)
+	provider.AuthorizeHandler = func(context *auth.Context) (*claims.Claims, error) {
+		return authorizeGitHub(provider, context)
+	}
+	return provider
+}
+
+func authorizeGitHub(provider *github.GithubProvider, context *auth.Context) (*claims.Claims, error) {
+	var (
+		authInfo auth_identity.Basic
+		req      = context.Request
+		ctx      = req.Context()
+		tx       = context.Auth.GetDB(req)
+	)
+
+	state, err := context.Auth.SessionStorer.ValidateClaims(req.URL.Query().Get("state"))
+	if err != nil || state.Valid() != nil || state.Subject != "state" {
+		return nil, auth.ErrUnauthorized
+	}
+
+	oauthCfg := provider.OAuthConfig(context)
+	tkn, err := oauthCfg.Exchange(ctx, req.URL.Query().Get("code"))
+	if err != nil {
+		return nil, err
+	}
+	client, err := config.GitHubClient(oauthCfg.Client(ctx, tkn))
+	if err != nil {
+		return nil, err
+	}
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	role, ok, err := gitHubRole(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, auth.ErrUnauthorized
+	}
+
+	authInfo.Provider = provider.GetName()
+	authInfo.UID = fmt.Sprint(user.GetID())
+	authIdentity := reflect.New(utils.ModelType(context.Auth.Config.AuthIdentityModel)).Interface()
+	if tx.Model(authIdentity).Where(authInfo).Scan(&authInfo).RecordNotFound() {
+		schema := auth.Schema{
+			Provider: provider.GetName(),
+			UID:      authInfo.UID,
+			Name:     user.GetName(),
+			Email:    user.GetEmail(),
+			Image:    user.GetAvatarURL(),
+			RawInfo:  user,
+		}
+		if schema.Name == "" {
+			schema.Name = user.GetLogin()
+		}
+		_, userID, err := context.Auth.UserStorer.Save(&schema, context)
+		if err != nil {
+			return nil, err
+		}
+		authInfo.UserID = userID
+		if err := tx.Where(authInfo).FirstOrCreate(authIdentity).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if role != "" {
+		if err := tx.Model(&models.User{}).Where("id = ?", authInfo.UserID).UpdateColumn("role", role).Error; err != nil {
+			return nil, err
+		}
+	}
+	return authInfo.ToClaims(), nil
+}
+
+// gitHubRole applies config.Config.GitHubLogin to the organizations and teams of the client's user.
+// ok is false if the user may not sign in, that is no rule matches and there is no DefaultRole.
+func gitHubRole(ctx context.Context, client *gh.Client) (role string, ok bool, err error) {
+	rules := config.Config.GitHubLogin.Roles
+	def := config.Config.GitHubLogin.DefaultRole
+	if len(rules) == 0 {
+		return def, def != "", nil
+	}
+
+	orgs := make(map[string]bool)
+	opt := &gh.ListOptions{PerPage: 100}
+	for {
+		list, resp, err := client.Organizations.List(ctx, "", opt)
+		if err != nil {
+			return "", false, err
+		}
+		for _, o := range list {
+			orgs[strings.ToLower(o.GetLogin())] = true
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	// teams are only listed if a rule needs them
+	var teams map[string]bool
+	for _, r := range rules {
+		if r.Team == "" || teams != nil {
+			continue
+		}
+		teams = make(map[string]bool)
+		opt := &gh.ListOptions{PerPage: 100}
+		for {
+			list, resp, err := client.Organizations.ListUserTeams(ctx, opt)
+			if err != nil {
+				return "", false, err
+			}
+			for _, t := range list {
+				teams[strings.ToLower(t.GetOrganization().GetLogin()+"/"+t.GetSlug())] = true
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+
+	for _, r := range rules {
+		org := strings.ToLower(r.Org)
+		if r.Team != "" && teams[org+"/"+strings.ToLower(r.Team)] || r.Team == "" && orgs[org] {
+			return r.Role, true, nil
+		}
+	}
+	return def, def != "", nil
+}
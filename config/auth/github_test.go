@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	gh "github.com/google/go-github/github"
+
+	"github.com/cryptix/synchrotron/config"
+)
+
+// fakeGitHub serves the organizations and teams of the signed in user, one per page
+func fakeGitHub(t *testing.T, orgs []string, teams map[string]string) *gh.Client {
+	page := func(w http.ResponseWriter, req *http.Request, n int) int {
+		p, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		if p < 1 {
+			p = 1
+		}
+		if p < n {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=%d>; rel="next"`, req.Host, req.URL.Path, p+1))
+		}
+		return p - 1
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/orgs", func(w http.ResponseWriter, req *http.Request) {
+		var list []*gh.Organization
+		if len(orgs) > 0 {
+			list = append(list, &gh.Organization{Login: gh.String(orgs[page(w, req, len(orgs))])})
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+	var teamList []*gh.Team
+	for org, slug := range teams {
+		teamList = append(teamList, &gh.Team{Slug: gh.String(slug), Organization: &gh.Organization{Login: gh.String(org)}})
+	}
+	mux.HandleFunc("/user/teams", func(w http.ResponseWriter, req *http.Request) {
+		var list []*gh.Team
+		if len(teamList) > 0 {
+			list = append(list, teamList[page(w, req, len(teamList))])
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	config.Config.GitHubAPI.BaseURL = srv.URL
+	client, err := config.GitHubClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestGitHubRole(t *testing.T) {
+	rules := []config.GitHubRole{
+		{Org: "Example", Team: "core", Role: "Admin"},
+		{Org: "example", Role: "Member"},
+		{Org: "friends", Role: "Maintainer"},
+	}
+	for _, tc := range []struct {
+		name        string
+		rules       []config.GitHubRole
+		defaultRole string
+		orgs        []string
+		teams       map[string]string
+		role        string
+		ok          bool
+	}{
+		{name: "no rules", orgs: []string{"example"}},
+		{name: "no rules with default", defaultRole: "Member", role: "Member", ok: true},
+		{name: "no match", rules: rules, orgs: []string{"other"}},
+		{name: "no match with default", rules: rules, defaultRole: "Member", orgs: []string{"other"}, role: "Member", ok: true},
+		{name: "org", rules: rules, orgs: []string{"other", "EXAMPLE"}, role: "Member", ok: true},
+		{name: "org on a later page", rules: rules, orgs: []string{"a", "b", "friends"}, role: "Maintainer", ok: true},
+		{name: "team wins", rules: rules, orgs: []string{"example"}, teams: map[string]string{"example": "core"}, role: "Admin", ok: true},
+		{name: "team of another org", rules: rules, orgs: []string{"example"}, teams: map[string]string{"other": "core"}, role: "Member", ok: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config.Config.GitHubLogin.Roles = tc.rules
+			config.Config.GitHubLogin.DefaultRole = tc.defaultRole
+			client := fakeGitHub(t, tc.orgs, tc.teams)
+
+			role, ok, err := gitHubRole(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if role != tc.role || ok != tc.ok {
+				t.Errorf("got %q, %v, want %q, %v", role, ok, tc.role, tc.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/qor/auth/auth_identity"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// UserByPassword checks login and password for clients that can't use the login form, like git over HTTP.
+// It accepts the password of the auth identity as well as one set through the admin.
+func UserByPassword(login, password string) *models.User {
+	login = strings.TrimSpace(login)
+	if login == "" || password == "" {
+		return nil
+	}
+
+	var identity auth_identity.AuthIdentity
+	if !db.DB.Where("provider = ? AND uid = ?", "password", login).First(&identity).RecordNotFound() {
+		if bcrypt.CompareHashAndPassword([]byte(identity.EncryptedPassword), []byte(password)) == nil {
+			var user models.User
+			if db.DB.First(&user, identity.UserID).Error == nil {
+				return &user
+			}
+		}
+	}
+
+	var user models.User
+	if db.DB.Where("email = ?", login).First(&user).RecordNotFound() || user.Password == "" {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return nil
+	}
+	return &user
+}
@@ -23,6 +23,19 @@ type SMTPConfig struct {
 	Password string
 }
 
+// VanityDomain serves Host/<name> as the mirror of Upstream/<name>, like go.example.org for github.com/example
+type VanityDomain struct {
+	Host     string
+	Upstream string
+}
+
+// GitHubRole gives Role to the members of the GitHub organization Org, or only to its Team if that's set
+type GitHubRole struct {
+	Org  string
+	Team string // the slug, like core-devs
+	Role string
+}
+
 var Config = struct {
 	Port int `default:"7000" env:"PORT"`
 	DB   struct {
@@ -33,6 +46,57 @@ var Config = struct {
 	TWAS   string `env:"TWAPI_SECRET" default:"sec"`
 	SMTP   SMTPConfig
 	Github github.Config
+	// GitHubAPI is used to import repositories
+	GitHubAPI struct {
+		BaseURL string `env:"GITHUB_API_URL" default:"https://api.github.com/"` // GitHub Enterprise or a local stand-in work as well
+		Token   string `env:"GITHUB_TOKEN"`                                     // the import runs as its owner
+	}
+	// GitHubLogin maps the GitHub organizations and teams of users that sign in to their Role
+	GitHubLogin struct {
+		Roles       []GitHubRole // the first match wins
+		DefaultRole string       // for users no rule matches, they can't sign in if it's empty
+	}
+	Mirror struct {
+		Dir string `env:"MIRROR_DIR" default:"mirrors"`
+	}
+	GitDaemon struct {
+		Port int `env:"GIT_DAEMON_PORT" default:"0"` // 0 disables the git:// listener, it's usually 9418
+	}
+	SSH struct {
+		Port    int    `env:"SSH_PORT" default:"0"`                       // 0 disables the SSH listener
+		HostKey string `env:"SSH_HOST_KEY" default:"config/ssh_host_key"` // created on first start
+	}
+	GoImport struct {
+		Prefix  string `default:"/go/"` // github.com/owner/name is served as <host>/go/github.com/owner/name
+		BaseURL string `env:"BASE_URL"` // like https://synchrotron.example, taken from the request if empty
+		Vanity  []VanityDomain
+	}
+	GoProxy struct {
+		CacheDir string `env:"GOPROXY_CACHE_DIR" default:"goproxy-cache"` // module zips built from the mirrors
+	}
+	Static struct {
+		Dir string `env:"STATIC_DIR" default:"static"` // default directory of static targets and -export-static
+	}
+	Bundle struct {
+		FullInterval uint `env:"BUNDLE_FULL_INTERVAL" default:"168"` // hours between full bundles of a repository
+	}
+	Deps struct {
+		Depth uint     `env:"DEPS_DEPTH" default:"1"` // dependency hops to follow from repositories added by hand, 0 turns discovery off
+		Allow []string // patterns like github.com/example/..., the import path and the host and path of the URL must match, everything is allowed if empty
+		Deny  []string // wins over Allow
+	}
+	Webhooks struct {
+		MaxAttempts uint `env:"WEBHOOK_MAX_ATTEMPTS" default:"10"` // tries of a delivery before it's marked as failed
+		Tick        uint `env:"WEBHOOK_TICK" default:"10"`         // seconds between checks for due retries
+		Timeout     uint `env:"WEBHOOK_TIMEOUT" default:"10"`      // seconds to wait for a subscriber
+	}
+	Worker struct {
+		Concurrency int `env:"WORKER_CONCURRENCY" default:"4"`
+	}
+	Poll struct {
+		Interval uint `env:"POLL_INTERVAL" default:"60"` // minutes between fetches of a repository
+		Tick     uint `env:"POLL_TICK" default:"60"`     // seconds between checks for due repositories
+	}
 }{}
 
 var (
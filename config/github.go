@@ -0,0 +1,24 @@
+package config
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// GitHubClient returns a client for Config.GitHubAPI, hc does the authentication
+func GitHubClient(hc *http.Client) (*github.Client, error) {
+	client := github.NewClient(hc)
+	base, err := url.Parse(Config.GitHubAPI.BaseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "github: invalid API URL")
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	client.BaseURL = base
+	return client, nil
+}
@@ -0,0 +1,97 @@
+package routes
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/config/utils"
+	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// goImport answers go get with a go-import meta tag that points to the git endpoint of the mirror.
+// The request path below strip is appended to upstream to get the path of the mirrored repository.
+type goImport struct {
+	strip    string
+	upstream string
+
+	// host is the start of the import paths, the host of the request is used if it's empty
+	host string
+
+	// rest serves everything but go get on vanity domains
+	rest http.Handler
+}
+
+var goImportPage = template.Must(template.New("go-import").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="{{.Root}} git {{.GitURL}}">
+</head>
+<body>
+go get {{.Root}}
+</body>
+</html>
+`))
+
+func (gi goImport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if gi.rest != nil && req.URL.Query().Get("go-get") != "1" {
+		// without a host the mux skips the vanity patterns, so git clients can use the same domain
+		r := *req
+		r.Host = ""
+		gi.rest.ServeHTTP(w, &r)
+		return
+	}
+	rel := strings.Trim(strings.TrimPrefix(req.URL.Path, gi.strip), "/")
+	repo, root, err := models.FindRepositoryByPath(db.DB, path.Join(gi.upstream, rel))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if repo == nil || !repo.CanRead(utils.GetCurrentUser(req)) {
+		http.NotFound(w, req)
+		return
+	}
+
+	base := baseURL(req)
+	host := gi.host
+	if host == "" {
+		host = base.Host + base.Path + strings.TrimSuffix(gi.strip, "/")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	goImportPage.Execute(w, map[string]string{
+		"Root":   path.Join(host, strings.TrimPrefix(root, gi.upstream)),
+		"GitURL": base.String() + "/git/" + repo.QualifiedName() + ".git",
+	})
+}
+
+// baseURL is where this server is reachable, the git endpoint is below it
+func baseURL(req *http.Request) *url.URL {
+	if u, err := url.Parse(config.Config.GoImport.BaseURL); err == nil && u.Host != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		return u
+	}
+	u := &url.URL{Scheme: "http", Host: req.Host}
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		u.Scheme = "https"
+	}
+	return u
+}
+
+// mountGoImport serves the mirrors below the configured prefix and every vanity domain
+func mountGoImport(mux *http.ServeMux) {
+	prefix := "/" + strings.Trim(config.Config.GoImport.Prefix, "/") + "/"
+	mux.Handle(prefix, goImport{strip: prefix})
+	for _, v := range config.Config.GoImport.Vanity {
+		// patterns with a host win over the ones without
+		mux.Handle(v.Host+"/", goImport{
+			strip:    "/",
+			upstream: strings.Trim(v.Upstream, "/"),
+			host:     v.Host,
+			rest:     mux,
+		})
+	}
+}
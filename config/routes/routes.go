@@ -6,20 +6,42 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi"
+	kitlog "github.com/go-kit/kit/log"
 	"github.com/qor/publish2"
 	"github.com/qor/qor"
-	"github.com/qor/qor/utils"
+	qorutils "github.com/qor/qor/utils"
 	"github.com/qor/wildcard_router"
 
 	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/config/admin"
 	"github.com/cryptix/synchrotron/config/admin/bindatafs"
 	"github.com/cryptix/synchrotron/config/auth"
+	"github.com/cryptix/synchrotron/config/utils"
 	"github.com/cryptix/synchrotron/controllers"
 	"github.com/cryptix/synchrotron/db"
+	"github.com/cryptix/synchrotron/ghapi"
+	"github.com/cryptix/synchrotron/gitserver"
+	"github.com/cryptix/synchrotron/goproxy"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/webhooks"
 )
 
 var rootMux *http.ServeMux
 var WildcardRouter *wildcard_router.WildcardRouter
+var gitServer *gitserver.Server
+
+// GitServer returns the server for the mirror store shared by all git transports
+func GitServer(l logging.Interface) *gitserver.Server {
+	if gitServer == nil {
+		gitServer = &gitserver.Server{
+			Store: mirror.Default,
+			DB:    db.DB,
+			Log:   kitlog.With(l, "unit", "gitserver"),
+		}
+	}
+	return gitServer
+}
 
 func Router(l logging.Interface) *http.ServeMux {
 	if rootMux == nil {
@@ -32,26 +54,64 @@ func Router(l logging.Interface) *http.ServeMux {
 					qorContext = &qor.Context{Request: req, Writer: w}
 				)
 
-				if locale := utils.GetLocale(qorContext); locale != "" {
+				if locale := qorutils.GetLocale(qorContext); locale != "" {
 					tx = tx.Set("l10n:locale", locale)
 				}
 
-				ctx := context.WithValue(req.Context(), utils.ContextDBName, publish2.PreviewByDB(tx, qorContext))
+				ctx := context.WithValue(req.Context(), qorutils.ContextDBName, publish2.PreviewByDB(tx, qorContext))
 				next.ServeHTTP(w, req.WithContext(ctx))
 			})
 		})
 
 		router.Get("/", controllers.HomeIndex)
 		router.Get("/switch_locale", controllers.SwitchLocale)
+		router.Get("/bundles/*", controllers.BundlesIndex)
+
+		router.Route("/api", func(r chi.Router) {
+			r.Get("/ref_updates", controllers.RefUpdatesIndex)
+		})
 
-		router.With(auth.Authority.Authorize()).Route("/account", func(r chi.Router) {
+		router.With(auth.Authority.Authorize(), utils.SameOrigin).Route("/account", func(r chi.Router) {
 			r.Get("/", controllers.AccountShow)
+			r.Post("/ssh_keys", controllers.SSHKeyCreate)
+			r.Post("/ssh_keys/{id}/delete", controllers.SSHKeyDelete)
 			//r.Post("/profile", controllers.SetUserProfile)
 		})
 
 		rootMux = http.NewServeMux()
 
 		rootMux.Handle("/auth/", auth.Auth.NewServeMux())
+		rootMux.Handle("/git/", gitserver.HTTPHandler{
+			Server:      GitServer(l),
+			Prefix:      "/git/",
+			CurrentUser: utils.GetCurrentUser,
+			BasicAuth:   auth.UserByPassword,
+		})
+		rootMux.Handle("/goproxy/", goproxy.Handler{
+			Store:       mirror.Default,
+			DB:          db.DB,
+			Log:         kitlog.With(l, "unit", "goproxy"),
+			Prefix:      "/goproxy/",
+			CacheDir:    config.Config.GoProxy.CacheDir,
+			CurrentUser: utils.GetCurrentUser,
+			BasicAuth:   auth.UserByPassword,
+		})
+		rootMux.Handle("/hooks/", webhooks.Handler{
+			DB:      db.DB,
+			Log:     kitlog.With(l, "unit", "webhooks"),
+			Prefix:  "/hooks/",
+			Enqueue: admin.EnqueueFetch,
+		})
+		rootMux.Handle("/api/v3/", ghapi.Handler{
+			Store:       mirror.Default,
+			DB:          db.DB,
+			Log:         kitlog.With(l, "unit", "ghapi"),
+			Prefix:      "/api/v3/",
+			BaseURL:     baseURL,
+			CurrentUser: utils.GetCurrentUser,
+			BasicAuth:   auth.UserByPassword,
+		})
+		mountGoImport(rootMux)
 		//rootMux.Handle("/system/", utils.FileServer(http.Dir(filepath.Join(config.Root, "public"))))
 		assetFS := bindatafs.AssetFS.FileServer(http.Dir("public"), "javascripts", "stylesheets", "images", "dist", "fonts", "vendors")
 		for _, path := range []string{"javascripts", "stylesheets", "images", "dist", "fonts", "vendors"} {
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SameOrigin rejects requests that change something unless their Origin, or their Referer without one, is this host.
+// Browsers send at least one of them with form posts, forms of other sites can't post here.
+func SameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, req)
+			return
+		}
+		origin := req.Header.Get("Origin")
+		if origin == "" || origin == "null" {
+			origin = req.Referer()
+		}
+		if u, err := url.Parse(origin); err != nil || origin == "" || u.Host != req.Host {
+			http.Error(w, "cross-site request refused", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// LaxCookies adds SameSite=Lax to the cookies next sets without a SameSite attribute,
+// the cookie store of the session doesn't know about it.
+func LaxCookies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(&laxCookieWriter{ResponseWriter: w}, req)
+	})
+}
+
+type laxCookieWriter struct {
+	http.ResponseWriter
+	done bool
+}
+
+// sameSite adds the attribute before the headers go out
+func (w *laxCookieWriter) sameSite() {
+	if w.done {
+		return
+	}
+	w.done = true
+	cookies := w.Header()["Set-Cookie"]
+	for i, c := range cookies {
+		if !strings.Contains(strings.ToLower(c), "samesite=") {
+			cookies[i] = c + "; SameSite=Lax"
+		}
+	}
+}
+
+func (w *laxCookieWriter) WriteHeader(code int) {
+	w.sameSite()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *laxCookieWriter) Write(p []byte) (int, error) {
+	w.sameSite()
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush keeps streaming responses like the git transports working
+func (w *laxCookieWriter) Flush() {
+	w.sameSite()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *laxCookieWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,17 +1,61 @@
 package controllers
 
 import (
+	"html/template"
 	"net/http"
 
+	"github.com/qor/session"
+	"github.com/qor/session/manager"
+
 	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/config/utils"
+	"github.com/cryptix/synchrotron/models"
 )
 
 func AccountShow(w http.ResponseWriter, req *http.Request) {
+	var keys []models.SSHKey
+	if user := utils.GetCurrentUser(req); user != nil {
+		utils.GetDB(req).Where("user_id = ?", user.ID).Order("id").Find(&keys)
+	}
 
 	config.View.Execute(
 		"account/show",
-		map[string]interface{}{},
+		map[string]interface{}{"SSHKeys": keys},
 		req,
 		w,
 	)
 }
+
+// SSHKeyCreate adds a public key to the account of the current user
+func SSHKeyCreate(w http.ResponseWriter, req *http.Request) {
+	user := utils.GetCurrentUser(req)
+	if user == nil {
+		http.Redirect(w, req, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	key := models.SSHKey{
+		UserID: user.ID,
+		Title:  req.FormValue("title"),
+		Key:    req.FormValue("key"),
+	}
+	if err := utils.GetDB(req).Create(&key).Error; err != nil {
+		manager.SessionManager.Flash(w, req, session.Message{Message: template.HTML(template.HTMLEscapeString(err.Error())), Type: "error"})
+	} else {
+		manager.SessionManager.Flash(w, req, session.Message{Message: "SSH key added", Type: "success"})
+	}
+	http.Redirect(w, req, "/account", http.StatusSeeOther)
+}
+
+// SSHKeyDelete removes one of the keys of the current user
+func SSHKeyDelete(w http.ResponseWriter, req *http.Request) {
+	user := utils.GetCurrentUser(req)
+	if user == nil {
+		http.Redirect(w, req, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	// hard delete, so the key can be added again later
+	utils.GetDB(req).Unscoped().Where("id = ? AND user_id = ?", utils.URLParam("id", req), user.ID).Delete(&models.SSHKey{})
+	http.Redirect(w, req, "/account", http.StatusSeeOther)
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/config/admin"
+	"github.com/cryptix/synchrotron/config/utils"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// BundlesIndex lists the git bundles of one repository, like /bundles/host/owner/name
+func BundlesIndex(w http.ResponseWriter, req *http.Request) {
+	repo, err := models.FindRepositoryByName(utils.GetDB(req), utils.URLParam("*", req))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if repo == nil || !repo.CanRead(utils.GetCurrentUser(req)) {
+		http.NotFound(w, req)
+		return
+	}
+
+	bundles, err := admin.ListBundles(repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	config.View.Execute(
+		"bundles/index",
+		map[string]interface{}{
+			"Repository": repo,
+			"Bundles":    bundles,
+			"BaseURL":    path.Join("/downloads", admin.BundleDir(repo)),
+		},
+		req,
+		w,
+	)
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/cryptix/synchrotron/config/utils"
+	"github.com/cryptix/synchrotron/models"
+)
+
+type refUpdateJSON struct {
+	ID         uint      `json:"id"`
+	Repository string    `json:"repository"`
+	Ref        string    `json:"ref"`
+	Old        string    `json:"old"`
+	New        string    `json:"new"`
+	JobID      string    `json:"job_id,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// RefUpdatesIndex answers "when did upstream move a ref and from what".
+// Results can be narrowed with the repository (name or id), ref, since (RFC3339) and limit query parameters.
+// Only repositories the current user may read are listed, others look like missing ones.
+func RefUpdatesIndex(w http.ResponseWriter, req *http.Request) {
+	var (
+		q     = req.URL.Query()
+		user  = utils.GetCurrentUser(req)
+		tx    = utils.GetDB(req).Preload("Repository").Order("id desc")
+		limit = 100
+	)
+
+	if repo := q.Get("repository"); repo != "" {
+		var (
+			r     models.Repository
+			where = utils.GetDB(req).Where("name = ?", repo)
+		)
+		if id, err := strconv.ParseUint(repo, 10, 64); err == nil {
+			where = utils.GetDB(req).Where("id = ?", id)
+		}
+		if where.First(&r).RecordNotFound() || !r.CanRead(user) {
+			http.Error(w, "no such repository", http.StatusNotFound)
+			return
+		}
+		tx = tx.Where("repository_id = ?", r.ID)
+	} else {
+		readable, err := readableRepositoryIDs(utils.GetDB(req), user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(readable) == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode([]refUpdateJSON{})
+			return
+		}
+		tx = tx.Where("repository_id IN (?)", readable)
+	}
+	if ref := q.Get("ref"); ref != "" {
+		tx = tx.Where("ref = ?", ref)
+	}
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		tx = tx.Where("created_at >= ?", t)
+	}
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	var updates []models.RefUpdate
+	if err := tx.Limit(limit).Find(&updates).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]refUpdateJSON, len(updates))
+	for i, u := range updates {
+		out[i] = refUpdateJSON{
+			ID:         u.ID,
+			Repository: u.Repository.Name,
+			Ref:        u.Ref,
+			Old:        u.OldHash,
+			New:        u.NewHash,
+			JobID:      u.JobID,
+			At:         u.CreatedAt,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// readableRepositoryIDs lists the repositories user may read, user is nil for anonymous requests
+func readableRepositoryIDs(tx *gorm.DB, user *models.User) ([]uint, error) {
+	var repos []models.Repository
+	if err := tx.Select("id, visibility").Find(&repos).Error; err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for _, r := range repos {
+		if r.CanRead(user) {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids, nil
+}
@@ -19,9 +19,13 @@ func init() {
 
 	AutoMigrate(&models.Setting{})
 
-	AutoMigrate(&models.User{})
+	AutoMigrate(&models.User{}, &models.SSHKey{})
 
-	AutoMigrate(&models.Repository{})
+	AutoMigrate(&models.Repository{}, &models.BranchHead{}, &models.Tag{}, &models.RefUpdate{}, &models.HookDelivery{})
+
+	AutoMigrate(&models.Webhook{}, &models.WebhookDelivery{})
+
+	AutoMigrate(&models.Target{}, &models.RepositoryTarget{})
 
 	AutoMigrate(&transition.StateChangeLog{})
 
@@ -0,0 +1,155 @@
+// Package deps finds the upstream repositories a Go project depends on.
+//
+// It reads the files of dep (Gopkg.lock), Go modules (go.mod) and govendor (vendor/vendor.json)
+// and maps the import paths in them to git repositories, like the go command does.
+package deps
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// Files are the dependency lists Parse understands, relative to the repository root
+var Files = []string{"Gopkg.lock", "go.mod", "vendor/vendor.json"}
+
+// Dependency is an import path from a dependency list
+type Dependency struct {
+	Path string
+
+	// Source is where to get Path from instead, an import path or a repository URL
+	Source string
+}
+
+// Parse returns the dependencies in data, name tells which of Files it is
+func Parse(name string, data []byte) ([]Dependency, error) {
+	switch name {
+	case "Gopkg.lock":
+		return parseGopkgLock(data)
+	case "go.mod":
+		return parseGoMod(data), nil
+	case "vendor/vendor.json":
+		return parseVendorJSON(data)
+	}
+	return nil, errors.Errorf("deps: unknown file %q", name)
+}
+
+func parseGopkgLock(data []byte) ([]Dependency, error) {
+	var lock struct {
+		Projects []struct {
+			Name   string `toml:"name"`
+			Source string `toml:"source"`
+		} `toml:"projects"`
+	}
+	if _, err := toml.Decode(string(data), &lock); err != nil {
+		return nil, errors.Wrap(err, "deps: invalid Gopkg.lock")
+	}
+	var ds []Dependency
+	for _, p := range lock.Projects {
+		if p.Name != "" {
+			ds = append(ds, Dependency{Path: p.Name, Source: p.Source})
+		}
+	}
+	return ds, nil
+}
+
+func parseVendorJSON(data []byte) ([]Dependency, error) {
+	var vendor struct {
+		Package []struct {
+			Path string `json:"path"`
+		} `json:"package"`
+	}
+	if err := json.Unmarshal(data, &vendor); err != nil {
+		return nil, errors.Wrap(err, "deps: invalid vendor.json")
+	}
+	var ds []Dependency
+	for _, p := range vendor.Package {
+		if p.Path != "" {
+			ds = append(ds, Dependency{Path: p.Path})
+		}
+	}
+	return ds, nil
+}
+
+// parseGoMod collects the require directives, replacements with another module win over the original
+func parseGoMod(data []byte) []Dependency {
+	var (
+		required []string
+		replaced = make(map[string]string)
+		block    string
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		verb := block
+		switch {
+		case block != "" && fields[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		case block == "":
+			verb, fields = fields[0], fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch verb {
+		case "require":
+			required = append(required, unquote(fields[0]))
+		case "replace":
+			// old [version] => new [version], local directories aren't modules to mirror
+			for i, f := range fields {
+				if f == "=>" && i+1 < len(fields) {
+					to := unquote(fields[i+1])
+					if !strings.HasPrefix(to, ".") && !strings.HasPrefix(to, "/") {
+						replaced[unquote(fields[0])] = to
+					}
+				}
+			}
+		}
+	}
+
+	var ds []Dependency
+	for _, p := range required {
+		ds = append(ds, Dependency{Path: p, Source: replaced[p]})
+	}
+	return ds
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// Match reports whether p is matched by one of patterns.
+// Like go list, ... matches any string, so github.com/example/... is everything of that owner.
+func Match(patterns []string, p string) bool {
+	for _, pat := range patterns {
+		re := "^" + strings.Replace(regexp.QuoteMeta(pat), `\.\.\.`, `.*`, -1) + "$"
+		if ok, _ := regexp.MatchString(re, p); ok {
+			return true
+		}
+		// a pattern for a repository also covers its packages
+		if strings.HasSuffix(pat, "/...") && p == strings.TrimSuffix(pat, "/...") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package deps
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// Repo is a git repository that holds the packages below Root
+type Repo struct {
+	Root string // import path prefix, like github.com/owner/name
+	URL  string
+}
+
+// Resolver maps import paths to repositories.
+// Well known hosts are handled locally, everything else asks the server with ?go-get=1.
+type Resolver struct {
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]Repo
+}
+
+// knownHosts have their repositories at host/owner/name
+var knownHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+
+// Resolve finds the repository for importPath
+func (r *Resolver) Resolve(ctx context.Context, importPath string) (Repo, error) {
+	importPath = strings.Trim(importPath, "/")
+	elems := strings.Split(importPath, "/")
+	for _, e := range elems {
+		if e == "" || e == "." || e == ".." {
+			return Repo{}, errors.Errorf("deps: invalid import path %q", importPath)
+		}
+	}
+
+	for _, host := range knownHosts {
+		if elems[0] == host {
+			if len(elems) < 3 {
+				return Repo{}, errors.Errorf("deps: invalid import path %q", importPath)
+			}
+			root := strings.Join(elems[:3], "/")
+			return Repo{Root: root, URL: "https://" + root}, nil
+		}
+	}
+	switch elems[0] {
+	case "golang.org":
+		if len(elems) >= 3 && elems[1] == "x" {
+			return Repo{Root: strings.Join(elems[:3], "/"), URL: "https://go.googlesource.com/" + elems[2]}, nil
+		}
+	case "gopkg.in":
+		// gopkg.in/pkg.v1 is github.com/go-pkg/pkg, gopkg.in/user/pkg.v1 is github.com/user/pkg
+		for i := 1; i < len(elems) && i <= 2; i++ {
+			if j := strings.LastIndex(elems[i], ".v"); j > 0 {
+				owner, name := "go-"+elems[i][:j], elems[i][:j]
+				if i == 2 {
+					owner = elems[1]
+				}
+				return Repo{Root: strings.Join(elems[:i+1], "/"), URL: "https://github.com/" + owner + "/" + name}, nil
+			}
+		}
+		return Repo{}, errors.Errorf("deps: invalid gopkg.in path %q", importPath)
+	}
+
+	r.mu.Lock()
+	for root, repo := range r.cache {
+		if importPath == root || strings.HasPrefix(importPath, root+"/") {
+			r.mu.Unlock()
+			return repo, nil
+		}
+	}
+	r.mu.Unlock()
+
+	repo, err := r.discover(ctx, importPath)
+	if err != nil {
+		return Repo{}, err
+	}
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = make(map[string]Repo)
+	}
+	r.cache[repo.Root] = repo
+	r.mu.Unlock()
+	return repo, nil
+}
+
+// discover reads the go-import meta tag of importPath
+func (r *Resolver) discover(ctx context.Context, importPath string) (Repo, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://"+importPath+"?go-get=1", nil)
+	if err != nil {
+		return Repo{}, errors.Wrap(err, "deps: invalid import path")
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Repo{}, errors.Wrapf(err, "deps: discovery of %s failed", importPath)
+	}
+	defer resp.Body.Close()
+
+	imports := parseMetaGoImports(io.LimitReader(resp.Body, 1<<20))
+	for _, imp := range imports {
+		if imp.vcs != "git" {
+			continue
+		}
+		if importPath == imp.prefix || strings.HasPrefix(importPath, imp.prefix+"/") {
+			return Repo{Root: imp.prefix, URL: imp.url}, nil
+		}
+	}
+	return Repo{}, errors.Errorf("deps: no git repository found for %s", importPath)
+}
+
+type metaImport struct {
+	prefix, vcs, url string
+}
+
+// parseMetaGoImports collects the go-import tags from the head of an HTML page
+func parseMetaGoImports(r io.Reader) []metaImport {
+	var imports []metaImport
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return imports
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return imports
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) == "body" {
+				return imports
+			}
+			if string(name) != "meta" || !hasAttr {
+				continue
+			}
+			var metaName, content string
+			for {
+				key, val, more := z.TagAttr()
+				switch string(key) {
+				case "name":
+					metaName = string(val)
+				case "content":
+					content = string(val)
+				}
+				if !more {
+					break
+				}
+			}
+			if fields := strings.Fields(content); metaName == "go-import" && len(fields) == 3 {
+				imports = append(imports, metaImport{prefix: fields[0], vcs: fields[1], url: fields[2]})
+			}
+		}
+	}
+}
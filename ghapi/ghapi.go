@@ -0,0 +1,173 @@
+// Package ghapi serves a subset of the GitHub v3 REST API from the database and the mirror store.
+//
+// Responses use the types of go-github, so a client with its BaseURL set to
+// https://synchrotron.example/api/v3/ reads repositories, branches, tags and commits
+// of the mirrors like it would from github.com.
+package ghapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jinzhu/gorm"
+
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+const (
+	defaultPerPage = 30
+	maxPerPage     = 100
+)
+
+// Handler answers GET requests below /<prefix>/repos/:owner/:repo
+type Handler struct {
+	Store *mirror.Store
+	DB    *gorm.DB
+	Log   logging.Interface
+
+	// Prefix is stripped from request paths, like /api/v3/
+	Prefix string
+
+	// BaseURL returns where this server is reachable, URLs in responses start with it
+	BaseURL func(req *http.Request) *url.URL
+
+	// CurrentUser returns the signed in user of req, if any
+	CurrentUser func(req *http.Request) *models.User
+
+	// BasicAuth checks the credentials API clients send
+	BasicAuth func(login, password string) *models.User
+}
+
+// apiError is the body of GitHub error responses, go-github reads it into an ErrorResponse
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// request is an API request about repo
+type request struct {
+	*http.Request
+	repo *models.Repository
+	// api is the URL of the repository in this API, like https://synchrotron.example/api/v3/repos/owner/name
+	api string
+	// base is the URL of the server
+	base string
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+	p := strings.Trim(strings.TrimPrefix(req.URL.Path, h.Prefix), "/")
+	parts := strings.SplitN(p, "/", 5)
+	if len(parts) < 3 || parts[0] != "repos" {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	repo, err := h.find(parts[1]+"/"+parts[2], models.RequestUser(req, h.BasicAuth, h.CurrentUser))
+	if err != nil {
+		h.Log.Log("event", "repo lookup failed", "path", p, "err", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if repo == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	base := h.BaseURL(req).String()
+	r := request{
+		Request: req,
+		repo:    repo,
+		api:     base + "/" + strings.Trim(h.Prefix, "/") + "/repos/" + repo.FullName,
+		base:    base,
+	}
+
+	var out interface{}
+	switch {
+	case len(parts) == 3:
+		out, err = h.repository(r)
+	case len(parts) == 4 && parts[3] == "branches":
+		out, err = h.branches(w, r)
+	case len(parts) == 5 && parts[3] == "branches":
+		// branch names may contain slashes
+		out, err = h.branch(r, parts[4])
+	case len(parts) == 4 && parts[3] == "tags":
+		out, err = h.tags(w, r)
+	case len(parts) == 5 && parts[3] == "commits":
+		out, err = h.commit(r, parts[4])
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	switch {
+	case err != nil:
+		kitlog.With(h.Log, "repo", repo.FullName, "path", p).Log("event", "serve failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+	case out == nil:
+		writeError(w, http.StatusNotFound, "Not Found")
+	default:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// find looks up the repository with fullName that user may read.
+// It resolves names like the git server does, so the clone URL leads to the same repository.
+func (h Handler) find(fullName string, user *models.User) (*models.Repository, error) {
+	repo, err := models.FindRepositoryByName(h.DB, fullName)
+	// unreadable repositories look like missing ones, just like on GitHub
+	if err != nil || repo == nil || !repo.CanRead(user) {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// paginate applies the page and per_page query parameters to tx and sets the Link header go-github follows
+func paginate(w http.ResponseWriter, r request, tx *gorm.DB, total int) *gorm.DB {
+	q := r.URL.Query()
+	perPage, err := strconv.Atoi(q.Get("per_page"))
+	if err != nil || perPage <= 0 {
+		perPage = defaultPerPage
+	} else if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	last := (total + perPage - 1) / perPage
+
+	link := func(n int, rel string) string {
+		u := *r.URL
+		v := u.Query()
+		v.Set("page", strconv.Itoa(n))
+		v.Set("per_page", strconv.Itoa(perPage))
+		u.RawQuery = v.Encode()
+		return fmt.Sprintf(`<%s%s>; rel="%s"`, r.base, u.RequestURI(), rel)
+	}
+	var links []string
+	if page < last {
+		links = append(links, link(page+1, "next"), link(last, "last"))
+	}
+	if page > 1 {
+		links = append(links, link(1, "first"), link(page-1, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	return tx.Offset((page - 1) * perPage).Limit(perPage)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(apiError{Message: msg})
+}
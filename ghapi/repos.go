@@ -0,0 +1,207 @@
+package ghapi
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// GET /repos/:owner/:repo
+func (h Handler) repository(r request) (interface{}, error) {
+	repo := r.repo
+	owner := strings.SplitN(repo.FullName, "/", 2)[0]
+	out := &github.Repository{
+		ID:          github.Int(int(repo.ID)),
+		Owner:       &github.User{Login: github.String(owner)},
+		Name:        github.String(repoName(repo.FullName)),
+		FullName:    github.String(repo.FullName),
+		Private:     github.Bool(repo.Visibility != models.VisibilityPublic),
+		Archived:    github.Bool(repo.State == models.RepoArchived),
+		Fork:        github.Bool(false),
+		CreatedAt:   timestamp(repo.CreatedAt),
+		UpdatedAt:   timestamp(repo.UpdatedAt),
+		CloneURL:    github.String(r.base + "/git/" + repo.QualifiedName() + ".git"),
+		URL:         github.String(r.api),
+		BranchesURL: github.String(r.api + "/branches{/branch}"),
+		TagsURL:     github.String(r.api + "/tags"),
+		CommitsURL:  github.String(r.api + "/commits{/sha}"),
+	}
+	if u := repo.PublicURL(); u != "" {
+		out.MirrorURL = github.String(u)
+	}
+	if repo.LastFetchedAt != nil {
+		out.PushedAt = timestamp(*repo.LastFetchedAt)
+	}
+	if h.Store.Exists(repo) {
+		branch, err := h.Store.DefaultBranch(r.Context(), repo)
+		if err != nil {
+			return nil, err
+		}
+		if branch != "" {
+			out.DefaultBranch = github.String(branch)
+		}
+	}
+	return out, nil
+}
+
+// GET /repos/:owner/:repo/branches
+func (h Handler) branches(w http.ResponseWriter, r request) (interface{}, error) {
+	tx := h.DB.Model(&models.BranchHead{}).Where("repository_id = ?", r.repo.ID)
+	var total int
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var heads []models.BranchHead
+	if err := paginate(w, r, tx.Order("name"), total).Find(&heads).Error; err != nil {
+		return nil, err
+	}
+	out := make([]*github.Branch, len(heads))
+	for i, head := range heads {
+		out[i] = &github.Branch{
+			Name: github.String(head.Name),
+			Commit: &github.RepositoryCommit{
+				SHA: github.String(head.Hash),
+				URL: github.String(r.api + "/commits/" + head.Hash),
+			},
+			Protected: github.Bool(false),
+		}
+	}
+	return out, nil
+}
+
+// GET /repos/:owner/:repo/branches/:branch
+func (h Handler) branch(r request, name string) (interface{}, error) {
+	var head models.BranchHead
+	q := h.DB.Where("repository_id = ? AND name = ?", r.repo.ID, name).First(&head)
+	if q.RecordNotFound() {
+		return nil, nil
+	} else if q.Error != nil {
+		return nil, q.Error
+	}
+	out := &github.Branch{
+		Name:      github.String(head.Name),
+		Protected: github.Bool(false),
+	}
+	c, err := h.Store.Commit(r.Context(), r.repo, head.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		out.Commit = repositoryCommit(r, c, false)
+	} else {
+		// not in the mirror (anymore), the database still knows the hash
+		out.Commit = &github.RepositoryCommit{
+			SHA: github.String(head.Hash),
+			URL: github.String(r.api + "/commits/" + head.Hash),
+		}
+	}
+	return out, nil
+}
+
+// GET /repos/:owner/:repo/tags
+func (h Handler) tags(w http.ResponseWriter, r request) (interface{}, error) {
+	tx := h.DB.Model(&models.Tag{}).Where("repository_id = ?", r.repo.ID)
+	var total int
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var tags []models.Tag
+	if err := paginate(w, r, tx.Order("name desc"), total).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	out := make([]*github.RepositoryTag, len(tags))
+	for i, tag := range tags {
+		// GitHub lists the commit of annotated tags, not the tag object
+		hash := tag.Target
+		if hash == "" {
+			hash = tag.Hash
+		}
+		out[i] = &github.RepositoryTag{
+			Name: github.String(tag.Name),
+			Commit: &github.Commit{
+				SHA: github.String(hash),
+				URL: github.String(r.api + "/commits/" + hash),
+			},
+		}
+	}
+	return out, nil
+}
+
+// GET /repos/:owner/:repo/commits/:sha, sha can be a branch or tag name as well
+func (h Handler) commit(r request, rev string) (interface{}, error) {
+	if !h.Store.Exists(r.repo) {
+		return nil, nil
+	}
+	c, err := h.Store.Commit(r.Context(), r.repo, rev)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return repositoryCommit(r, c, true), nil
+}
+
+// repositoryCommit converts c, files adds the stats and changed files like GitHub does for single commits
+func repositoryCommit(r request, c *mirror.Commit, files bool) *github.RepositoryCommit {
+	out := &github.RepositoryCommit{
+		SHA: github.String(c.Hash),
+		Commit: &github.Commit{
+			Author:       commitAuthor(c.Author),
+			Committer:    commitAuthor(c.Committer),
+			Message:      github.String(c.Message),
+			Tree:         &github.Tree{SHA: github.String(c.Tree)},
+			URL:          github.String(r.api + "/git/commits/" + c.Hash),
+			CommentCount: github.Int(0),
+		},
+		URL: github.String(r.api + "/commits/" + c.Hash),
+	}
+	for _, p := range c.Parents {
+		out.Parents = append(out.Parents, github.Commit{
+			SHA: github.String(p),
+			URL: github.String(r.api + "/commits/" + p),
+		})
+	}
+	if !files {
+		return out
+	}
+	var additions, deletions int
+	for _, f := range c.Files {
+		additions += f.Additions
+		deletions += f.Deletions
+		out.Files = append(out.Files, github.CommitFile{
+			Filename:  github.String(f.Name),
+			Status:    github.String(f.Status),
+			Additions: github.Int(f.Additions),
+			Deletions: github.Int(f.Deletions),
+			Changes:   github.Int(f.Additions + f.Deletions),
+		})
+	}
+	out.Stats = &github.CommitStats{
+		Additions: github.Int(additions),
+		Deletions: github.Int(deletions),
+		Total:     github.Int(additions + deletions),
+	}
+	return out
+}
+
+func commitAuthor(s mirror.Signature) *github.CommitAuthor {
+	when := s.When.UTC()
+	return &github.CommitAuthor{
+		Name:  github.String(s.Name),
+		Email: github.String(s.Email),
+		Date:  &when,
+	}
+}
+
+// timestamp drops what GitHub doesn't send, it has second precision in UTC
+func timestamp(t time.Time) *github.Timestamp {
+	return &github.Timestamp{Time: t.UTC().Truncate(time.Second)}
+}
+
+// repoName returns the name part of owner/name
+func repoName(fullName string) string {
+	return fullName[strings.LastIndex(fullName, "/")+1:]
+}
@@ -0,0 +1,129 @@
+package gitserver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// Daemon serves the anonymous git:// protocol, read-only.
+// Only repositories readable without a user are visible.
+type Daemon struct {
+	*Server
+
+	// Addr to listen on, like :9418
+	Addr string
+}
+
+// ListenAndServe accepts connections until ctx is canceled
+func (d Daemon) ListenAndServe(ctx context.Context) error {
+	l, err := net.Listen("tcp", d.Addr)
+	if err != nil {
+		return errors.Wrap(err, "gitserver: daemon listen failed")
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	d.Log.Log("event", "listening", "transport", "daemon", "addr", l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return errors.Wrap(err, "gitserver: daemon accept failed")
+		}
+		go d.serve(ctx, conn)
+	}
+}
+
+func (d Daemon) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	log := kitlog.With(d.Log, "transport", "daemon", "remote", conn.RemoteAddr().String())
+
+	// the client has to say what it wants right away
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := readPktLine(r)
+	if err != nil {
+		log.Log("event", "bad request", "err", err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// git-upload-pack /owner/name.git\0host=example.com\0\0version=2\0
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), "\x00")
+	cmd := strings.SplitN(fields[0], " ", 2)
+	if len(cmd) != 2 {
+		io.WriteString(conn, pktLine("ERR invalid request\n"))
+		return
+	}
+	var extra []string
+	for i, f := range fields[1:] {
+		if f == "" && i+2 < len(fields) {
+			// everything after the empty field are extra parameters
+			for _, p := range fields[i+2:] {
+				if p != "" {
+					extra = append(extra, p)
+				}
+			}
+			break
+		}
+	}
+	proto := strings.Join(extra, ":")
+
+	log = kitlog.With(log, "service", cmd[0], "path", cmd[1], "protocol", proto)
+	if cmd[0] != "git-upload-pack" {
+		io.WriteString(conn, pktLine("ERR this mirror is read-only\n"))
+		return
+	}
+
+	repo, err := d.Open(cmd[1], nil)
+	if err == ErrNotFound {
+		io.WriteString(conn, pktLine("ERR repository not found\n"))
+		return
+	} else if err != nil {
+		log.Log("event", "open failed", "err", err)
+		io.WriteString(conn, pktLine("ERR internal error\n"))
+		return
+	}
+
+	if err := d.uploadPack(ctx, repo, protocolEnv(proto), r, conn, "--strict", "--timeout=600"); err != nil {
+		log.Log("event", "serve failed", "err", err)
+	}
+}
+
+// readPktLine reads one pkt-line from r. Flush packets are returned as an empty string.
+func readPktLine(r io.Reader) (string, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", errors.Wrap(err, "gitserver: reading pkt-line length failed")
+	}
+	n, err := strconv.ParseUint(string(hdr[:]), 16, 16)
+	if err != nil {
+		return "", errors.Wrap(err, "gitserver: invalid pkt-line length")
+	}
+	if n == 0 {
+		return "", nil
+	}
+	if n < 4 {
+		return "", errors.Errorf("gitserver: invalid pkt-line length %d", n)
+	}
+	buf := make([]byte, n-4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", errors.Wrap(err, "gitserver: reading pkt-line failed")
+	}
+	return string(buf), nil
+}
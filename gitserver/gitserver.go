@@ -0,0 +1,82 @@
+// Package gitserver serves the mirror store read-only to git clients.
+package gitserver
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// ErrNotFound is returned for repositories that don't exist or that the user may not read
+var ErrNotFound = errors.New("gitserver: repository not found")
+
+// Server looks up repositories and runs git upload-pack for them
+type Server struct {
+	Store *mirror.Store
+	DB    *gorm.DB
+	Log   logging.Interface
+}
+
+// Open finds the repository for a request path like host/owner/name.git and checks that user may read it.
+// owner/name.git works too while no other host has a repository of that name, see models.FindRepositoryByName.
+func (s *Server) Open(repoPath string, user *models.User) (*models.Repository, error) {
+	repo, err := models.FindRepositoryByName(s.DB, strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git"))
+	if err != nil {
+		return nil, err
+	}
+	if repo == nil || !repo.CanRead(user) || !s.Store.Exists(repo) {
+		return nil, ErrNotFound
+	}
+	return repo, nil
+}
+
+// protocolEnv passes the protocol negotiated by the client (like version=2) on to upload-pack.
+// It comes from the Git-Protocol header over HTTP, so only plain key=value pairs are accepted.
+func protocolEnv(proto string) []string {
+	if proto == "" || len(proto) > 256 {
+		return nil
+	}
+	for _, r := range proto {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("=:._-", r):
+		default:
+			return nil
+		}
+	}
+	return []string{"GIT_PROTOCOL=" + proto}
+}
+
+// isV2 reports whether proto asks for protocol version 2
+func isV2(proto string) bool {
+	for _, kv := range strings.Split(proto, ":") {
+		if kv == "version=2" {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadPack runs git upload-pack on the mirror of repo with the given extra arguments
+func (s *Server) uploadPack(ctx context.Context, repo *models.Repository, env []string, stdin io.Reader, stdout io.Writer, args ...string) error {
+	args = append(append([]string{"upload-pack"}, args...), s.Store.Path(repo))
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "gitserver: upload-pack failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package gitserver
+
+import (
+	"testing"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+func TestOpenSameFullName(t *testing.T) {
+	f := newFixture(t)
+
+	open := func(p string) *models.Repository {
+		repo, err := f.server.Open(p, nil)
+		if err == ErrNotFound {
+			return nil
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+
+	first := open("acme/app.git")
+	if first == nil {
+		t.Fatal("acme/app.git not found while only one host has it")
+	}
+
+	// a mirror of the same owner/name from another host
+	other := models.Repository{
+		Name:       "app",
+		URL:        "https://gitlab.example/acme/app.git",
+		Visibility: models.VisibilityPublic,
+	}
+	if err := f.server.DB.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	f.git(t, "", "clone", "--quiet", "--bare", f.upstream, f.server.Store.Path(&other))
+
+	if repo := open("acme/app.git"); repo != nil {
+		t.Errorf("acme/app.git is ambiguous but served repository %d", repo.ID)
+	}
+	for p, want := range map[string]uint{
+		"example.com/acme/app.git":    first.ID,
+		"gitlab.example/acme/app.git": other.ID,
+		"/gitlab.example/acme/app":    other.ID,
+	} {
+		if repo := open(p); repo == nil || repo.ID != want {
+			t.Errorf("%s served %v, want repository %d", p, repo, want)
+		}
+	}
+	if repo := open("github.com/acme/app.git"); repo != nil {
+		t.Errorf("github.com/acme/app.git served repository %d of another host", repo.ID)
+	}
+}
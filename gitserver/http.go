@@ -0,0 +1,117 @@
+package gitserver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// HTTPHandler serves the smart HTTP protocol of git, read-only
+type HTTPHandler struct {
+	*Server
+
+	// Prefix is stripped from request paths, like /git/
+	Prefix string
+
+	// CurrentUser returns the signed in user of req, if any
+	CurrentUser func(req *http.Request) *models.User
+
+	// BasicAuth checks the credentials git clients send
+	BasicAuth func(login, password string) *models.User
+}
+
+func (h HTTPHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p := strings.TrimPrefix(req.URL.Path, h.Prefix)
+
+	var repoPath, action string
+	for _, a := range []string{"/info/refs", "/git-upload-pack", "/git-receive-pack"} {
+		if strings.HasSuffix(p, a) {
+			repoPath, action = strings.TrimSuffix(p, a), a
+			break
+		}
+	}
+	if action == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	service := req.URL.Query().Get("service")
+	if action == "/git-receive-pack" || service == "git-receive-pack" {
+		http.Error(w, "this mirror is read-only", http.StatusForbidden)
+		return
+	}
+
+	user := models.RequestUser(req, h.BasicAuth, h.CurrentUser)
+	repo, err := h.Open(repoPath, user)
+	if err == ErrNotFound && user == nil {
+		// maybe it's not public, give the client a chance to send credentials
+		w.Header().Set("WWW-Authenticate", `Basic realm="synchrotron"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	} else if err == ErrNotFound {
+		http.NotFound(w, req)
+		return
+	} else if err != nil {
+		h.Log.Log("event", "repo lookup failed", "path", repoPath, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// clients that speak protocol v2 say so in this header, everyone else gets v0
+	proto := req.Header.Get("Git-Protocol")
+	env := protocolEnv(proto)
+
+	log := kitlog.With(h.Log, "repo", repo.FullName, "action", strings.TrimPrefix(action, "/"), "protocol", proto)
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+	w.Header().Set("Vary", "Git-Protocol")
+	switch {
+	case action == "/info/refs" && req.Method == http.MethodGet:
+		if service != "git-upload-pack" {
+			http.Error(w, "dumb http is not served here", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		// v2 starts with the capability advertisement right away
+		if env == nil || !isV2(proto) {
+			io.WriteString(w, pktLine("# service=git-upload-pack\n")+"0000")
+		}
+		err = h.uploadPack(req.Context(), repo, env, nil, w, "--stateless-rpc", "--advertise-refs")
+
+	case action == "/git-upload-pack" && req.Method == http.MethodPost:
+		if req.Header.Get("Content-Type") != "application/x-git-upload-pack-request" {
+			http.Error(w, "unexpected content type", http.StatusBadRequest)
+			return
+		}
+		body := io.Reader(req.Body)
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			gz, gzErr := gzip.NewReader(req.Body)
+			if gzErr != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+		err = h.uploadPack(req.Context(), repo, env, body, w, "--stateless-rpc")
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		// the status line is out already, all we can do is log
+		log.Log("event", "serve failed", "err", err)
+	}
+}
+
+// pktLine encodes s in git's pkt-line format
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
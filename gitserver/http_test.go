@@ -0,0 +1,202 @@
+package gitserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jinzhu/gorm"
+
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// fixture serves a mirror of a repository with one commit on master as /git/acme/app.git
+type fixture struct {
+	srv      *httptest.Server
+	server   *Server
+	dir      string
+	upstream string
+	head     string
+}
+
+func newFixture(t *testing.T) *fixture {
+	dir := t.TempDir()
+	f := &fixture{dir: dir}
+
+	upstream := filepath.Join(dir, "upstream")
+	f.upstream = upstream
+	f.git(t, "", "init", "--quiet", "--initial-branch=master", upstream)
+	if err := ioutil.WriteFile(filepath.Join(upstream, "README"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.git(t, upstream, "add", "README")
+	f.git(t, upstream, "commit", "--quiet", "-m", "initial")
+	f.head = strings.TrimSpace(f.git(t, upstream, "rev-parse", "HEAD"))
+
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.AutoMigrate(&models.Repository{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	repo := models.Repository{
+		Name:       "app",
+		URL:        "https://example.com/acme/app.git",
+		Visibility: models.VisibilityPublic,
+	}
+	if err := db.Create(&repo).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	store := mirror.New(filepath.Join(dir, "mirrors"))
+	f.git(t, "", "clone", "--quiet", "--bare", upstream, store.Path(&repo))
+
+	f.server = &Server{Store: store, DB: db, Log: kitlog.NewNopLogger()}
+	f.srv = httptest.NewServer(HTTPHandler{
+		Server: f.server,
+		Prefix: "/git/",
+	})
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fixture) url() string {
+	return f.srv.URL + "/git/acme/app.git"
+}
+
+// git runs a git client that ignores the config of the user running the tests
+func (f *fixture) git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, stderr, err := f.run(dir, args...)
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return out
+}
+
+func (f *fixture) run(dir string, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"HOME="+f.dir,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		// the packet trace shows which protocol version was spoken
+		"GIT_TRACE_PACKET=1",
+	)
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestHTTPProtocolVersions(t *testing.T) {
+	f := newFixture(t)
+
+	for _, tc := range []struct {
+		version string
+		v2      bool
+	}{
+		{"2", true},
+		{"0", false},
+	} {
+		t.Run("version="+tc.version, func(t *testing.T) {
+			out, trace, err := f.run("", "-c", "protocol.version="+tc.version, "ls-remote", f.url())
+			if err != nil {
+				t.Fatalf("ls-remote: %v\n%s", err, trace)
+			}
+			if want := f.head + "\trefs/heads/master"; !strings.Contains(out, want) {
+				t.Errorf("ls-remote output %q is missing %q", out, want)
+			}
+			if got := strings.Contains(trace, "< version 2"); got != tc.v2 {
+				t.Errorf("server spoke version 2: %v, want %v", got, tc.v2)
+			}
+
+			clone := filepath.Join(f.dir, "clone-v"+tc.version)
+			if _, trace, err := f.run("", "-c", "protocol.version="+tc.version, "clone", "--quiet", f.url(), clone); err != nil {
+				t.Fatalf("clone: %v\n%s", err, trace)
+			}
+			if head := strings.TrimSpace(f.git(t, clone, "rev-parse", "HEAD")); head != f.head {
+				t.Errorf("cloned HEAD is %s, want %s", head, f.head)
+			}
+		})
+	}
+}
+
+func TestHTTPAdvertisement(t *testing.T) {
+	f := newFixture(t)
+
+	get := func(proto string) string {
+		req, err := http.NewRequest(http.MethodGet, f.url()+"/info/refs?service=git-upload-pack", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proto != "" {
+			req.Header.Set("Git-Protocol", proto)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("info/refs with Git-Protocol %q: %s", proto, resp.Status)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	v2 := get("version=2")
+	if strings.Contains(v2, "# service=") {
+		t.Errorf("v2 advertisement has the v0 preamble: %q", v2)
+	}
+	if !strings.HasPrefix(v2, pktLine("version 2\n")) {
+		t.Errorf("v2 advertisement doesn't start with the version: %q", v2)
+	}
+
+	v0 := get("")
+	if !strings.HasPrefix(v0, pktLine("# service=git-upload-pack\n")+"0000") {
+		t.Errorf("v0 advertisement is missing the preamble: %q", v0)
+	}
+	if !strings.Contains(v0, f.head+" HEAD") {
+		t.Errorf("v0 advertisement doesn't list HEAD: %q", v0)
+	}
+}
+
+func TestProtocolEnv(t *testing.T) {
+	for _, tc := range []struct {
+		proto string
+		env   []string
+		v2    bool
+	}{
+		{"", nil, false},
+		{"version=2", []string{"GIT_PROTOCOL=version=2"}, true},
+		{"version=1:object-format=sha1", []string{"GIT_PROTOCOL=version=1:object-format=sha1"}, false},
+		{"version=2\nX=1", nil, false},
+		{"version=2 $(id)", nil, false},
+	} {
+		env := protocolEnv(tc.proto)
+		if strings.Join(env, ",") != strings.Join(tc.env, ",") {
+			t.Errorf("protocolEnv(%q) = %q, want %q", tc.proto, env, tc.env)
+		}
+		if got := isV2(tc.proto); got != tc.v2 {
+			t.Errorf("isV2(%q) = %v, want %v", tc.proto, got, tc.v2)
+		}
+	}
+}
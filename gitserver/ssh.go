@@ -0,0 +1,203 @@
+package gitserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// SSHServer serves git-upload-pack over SSH to users with a registered models.SSHKey
+type SSHServer struct {
+	*Server
+
+	// Addr to listen on, like :2222
+	Addr    string
+	HostKey ssh.Signer
+}
+
+// LoadHostKey reads the PEM encoded host key at path and creates one if it doesn't exist yet
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		// not RSA, the vendored ssh package only signs with ssh-rsa (SHA-1) which current clients refuse
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "gitserver: generating host key failed")
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "gitserver: encoding host key failed")
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			return nil, errors.Wrap(err, "gitserver: writing host key failed")
+		}
+	} else if err != nil {
+		return nil, errors.Wrap(err, "gitserver: reading host key failed")
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	return signer, errors.Wrap(err, "gitserver: parsing host key failed")
+}
+
+// ListenAndServe accepts connections until ctx is canceled
+func (s SSHServer) ListenAndServe(ctx context.Context) error {
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: s.checkKey,
+	}
+	cfg.AddHostKey(s.HostKey)
+
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return errors.Wrap(err, "gitserver: ssh listen failed")
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	s.Log.Log("event", "listening", "transport", "ssh", "addr", l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return errors.Wrap(err, "gitserver: ssh accept failed")
+		}
+		go s.serve(ctx, cfg, conn)
+	}
+}
+
+// checkKey accepts keys that belong to a user and remembers which one in the permissions
+func (s SSHServer) checkKey(meta ssh.ConnMetadata, pub ssh.PublicKey) (*ssh.Permissions, error) {
+	var key models.SSHKey
+	err := s.DB.Where("fingerprint = ?", ssh.FingerprintSHA256(pub)).First(&key).Error
+	if err != nil {
+		return nil, errors.New("unknown public key")
+	}
+	return &ssh.Permissions{
+		Extensions: map[string]string{"user-id": strconv.FormatUint(uint64(key.UserID), 10)},
+	}, nil
+}
+
+func (s SSHServer) serve(ctx context.Context, cfg *ssh.ServerConfig, conn net.Conn) {
+	defer conn.Close()
+	log := kitlog.With(s.Log, "transport", "ssh", "remote", conn.RemoteAddr().String())
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		log.Log("event", "handshake failed", "err", err)
+		return
+	}
+	defer sconn.Close()
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(reqs)
+
+	var user models.User
+	if err := s.DB.First(&user, sconn.Permissions.Extensions["user-id"]).Error; err != nil {
+		log.Log("event", "user lookup failed", "err", err)
+		return
+	}
+	log = kitlog.With(log, "user", user.Email)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			log.Log("event", "channel accept failed", "err", err)
+			continue
+		}
+		go s.session(ctx, log, &user, ch, chReqs)
+	}
+}
+
+// session waits for the exec request and runs upload-pack for it. There is no shell.
+func (s SSHServer) session(ctx context.Context, log kitlog.Logger, user *models.User, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	var env []string
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			if err := ssh.Unmarshal(req.Payload, &kv); err == nil && kv.Name == "GIT_PROTOCOL" {
+				env = protocolEnv(kv.Value)
+				req.Reply(env != nil, nil)
+				continue
+			}
+			req.Reply(false, nil)
+
+		case "exec":
+			var cmd struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &cmd); err != nil {
+				req.Reply(false, nil)
+				return
+			}
+			req.Reply(true, nil)
+			status := s.exec(ctx, kitlog.With(log, "command", cmd.Command), user, ch, env, cmd.Command)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+
+		case "shell":
+			req.Reply(true, nil)
+			fmt.Fprintf(ch.Stderr(), "Hi %s! This server only serves git fetches.\n", user.Email)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+			return
+
+		default:
+			// no pty, no subsystems
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s SSHServer) exec(ctx context.Context, log kitlog.Logger, user *models.User, ch ssh.Channel, env []string, command string) uint32 {
+	// git-upload-pack '/owner/name.git'
+	args := strings.SplitN(strings.TrimSpace(command), " ", 2)
+	if len(args) != 2 {
+		fmt.Fprintln(ch.Stderr(), "invalid command")
+		return 1
+	}
+	if args[0] != "git-upload-pack" {
+		fmt.Fprintln(ch.Stderr(), "this mirror is read-only")
+		return 1
+	}
+
+	repo, err := s.Open(strings.Trim(args[1], "'\""), user)
+	if err == ErrNotFound {
+		fmt.Fprintln(ch.Stderr(), "repository not found")
+		return 1
+	} else if err != nil {
+		log.Log("event", "open failed", "err", err)
+		fmt.Fprintln(ch.Stderr(), "internal error")
+		return 1
+	}
+
+	if err := s.uploadPack(ctx, repo, env, ch, ch, "--strict", "--timeout=600"); err != nil {
+		log.Log("event", "serve failed", "err", err)
+		return 1
+	}
+	return 0
+}
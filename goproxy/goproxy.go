@@ -0,0 +1,227 @@
+// Package goproxy serves the GOPROXY protocol from the mirror store.
+//
+// Module versions are the semver tags of the mirrored repositories, so with
+// GOPROXY=https://synchrotron.example/goproxy the go command works without reaching upstream.
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+var errNotFound = errors.New("goproxy: not found")
+
+// Handler answers the requests of the go command for modules in mirrored repositories
+type Handler struct {
+	Store *mirror.Store
+	DB    *gorm.DB
+	Log   logging.Interface
+
+	// Prefix is stripped from request paths, like /goproxy/
+	Prefix string
+
+	// CacheDir keeps the zips that were built, they never change for a version
+	CacheDir string
+
+	// CurrentUser returns the signed in user of req, if any
+	CurrentUser func(req *http.Request) *models.User
+
+	// BasicAuth checks the credentials from .netrc
+	BasicAuth func(login, password string) *models.User
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimPrefix(req.URL.Path, h.Prefix)
+
+	var escPath, file string
+	if strings.HasSuffix(p, "/@latest") {
+		escPath, file = strings.TrimSuffix(p, "/@latest"), "@latest"
+	} else if i := strings.LastIndex(p, "/@v/"); i >= 0 {
+		escPath, file = p[:i], p[i+len("/@v/"):]
+	} else {
+		http.NotFound(w, req)
+		return
+	}
+	modPath, ok := unescape(escPath)
+	if !ok {
+		http.Error(w, "invalid module path", http.StatusNotFound)
+		return
+	}
+
+	log := kitlog.With(h.Log, "module", modPath, "file", file)
+	ctx := req.Context()
+	m, err := h.find(modPath, models.RequestUser(req, h.BasicAuth, h.CurrentUser))
+	if err == nil {
+		err = h.serve(ctx, w, req, m, file)
+	}
+	switch {
+	case err == errNotFound:
+		// the go command falls back to the next proxy on 404 and 410
+		http.Error(w, "not found: "+modPath+" "+file, http.StatusNotFound)
+	case err != nil:
+		log.Log("event", "serve failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h Handler) serve(ctx context.Context, w http.ResponseWriter, req *http.Request, m *module, file string) error {
+	if file == "@latest" {
+		info, err := m.latest(ctx)
+		if err != nil {
+			return err
+		}
+		return writeInfo(w, info)
+	}
+	if file == "list" {
+		vs, err := m.versions(ctx)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, v := range sortedVersions(vs) {
+			// pseudo-versions aren't listed, just like tags that only look like one
+			w.Write([]byte(v + "\n"))
+		}
+		return nil
+	}
+
+	ext := filepath.Ext(file)
+	version, ok := unescape(strings.TrimSuffix(file, ext))
+	if !ok {
+		return errNotFound
+	}
+	info, err := m.stat(ctx, version)
+	if err != nil {
+		return err
+	}
+	switch ext {
+	case ".info":
+		return writeInfo(w, info)
+	}
+	// only .info resolves queries, the rest needs the canonical version
+	if info.Version != version {
+		return errNotFound
+	}
+	switch ext {
+	case ".mod":
+		_, gomod, err := m.goMod(ctx, info.commit)
+		if err != nil {
+			return err
+		}
+		if gomod == nil {
+			gomod = []byte("module " + m.path + "\n")
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = w.Write(gomod)
+		return err
+
+	case ".zip":
+		zipFile, err := h.zip(ctx, m, info)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(zipFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/zip")
+		http.ServeContent(w, req, filepath.Base(zipFile), info.Time, f)
+		return nil
+	}
+	return errNotFound
+}
+
+// zip returns the cached zip for info and builds it first if needed
+func (h Handler) zip(ctx context.Context, m *module, info *revInfo) (string, error) {
+	file := filepath.Join(h.CacheDir, filepath.FromSlash(m.path), "@v", info.Version+"-"+info.commit+".zip")
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", errors.Wrap(err, "goproxy: creating cache dir failed")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".tmp-")
+	if err != nil {
+		return "", errors.Wrap(err, "goproxy: creating zip failed")
+	}
+	defer os.Remove(tmp.Name())
+	err = m.writeZip(ctx, tmp, info.Version, info.commit)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return file, errors.Wrap(os.Rename(tmp.Name(), file), "goproxy: caching zip failed")
+}
+
+// find maps a module path to the repository it lives in
+func (h Handler) find(modPath string, user *models.User) (*module, error) {
+	root, pathMajor := splitPathVersion(modPath)
+	repo, repoRoot, err := models.FindRepositoryByPath(h.DB, root)
+	if err != nil {
+		return nil, errors.Wrap(err, "goproxy: looking up repository failed")
+	}
+	if repo == nil || !repo.CanRead(user) || !h.Store.Exists(repo) {
+		return nil, errNotFound
+	}
+	return &module{
+		path:      modPath,
+		repo:      repo,
+		gitDir:    h.Store.Path(repo),
+		codeDir:   strings.Trim(strings.TrimPrefix(root, repoRoot), "/"),
+		pathMajor: pathMajor,
+	}, nil
+}
+
+func writeInfo(w http.ResponseWriter, info *revInfo) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(struct {
+		Version string
+		Time    string
+	}{info.Version, info.Time.UTC().Format(time.RFC3339)})
+}
+
+// unescape reverses the case encoding of module paths and versions, !x stands for X
+func unescape(s string) (string, bool) {
+	var (
+		b    strings.Builder
+		bang bool
+	)
+	for _, r := range s {
+		switch {
+		case bang:
+			if r < 'a' || r > 'z' {
+				return "", false
+			}
+			b.WriteRune(r - 'a' + 'A')
+			bang = false
+		case r == '!':
+			bang = true
+		case r >= 'A' && r <= 'Z':
+			return "", false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), !bang && s != ""
+}
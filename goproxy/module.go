@@ -0,0 +1,405 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// module is a Go module inside a mirrored repository
+type module struct {
+	path   string // like example.com/owner/name/sub/v2
+	repo   *models.Repository
+	gitDir string
+
+	// codeDir is the directory of the module inside the repository and the prefix of its tags
+	codeDir string
+	// pathMajor is the major version suffix of path, like /v2. It's empty for v0 and v1.
+	pathMajor string
+}
+
+// revInfo is what .info and @latest return
+type revInfo struct {
+	Version string
+	Time    time.Time
+
+	commit string
+}
+
+// splitPathVersion splits the /vN suffix off a module path
+func splitPathVersion(p string) (prefix, pathMajor string) {
+	i := strings.LastIndex(p, "/v")
+	if i < 0 || !isNum(p[i+2:]) || p[i+2] == '0' || p[i+2:] == "1" {
+		return p, ""
+	}
+	return p[:i], p[i:]
+}
+
+// versions lists the tags that are valid versions of m, with the commit they point to
+func (m *module) versions(ctx context.Context) (map[string]string, error) {
+	refs, err := mirror.ListRefs(ctx, m.gitDir, "refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if m.codeDir != "" {
+		prefix = m.codeDir + "/"
+	}
+
+	var (
+		vs           = make(map[string]string)
+		incompatible = make(map[string]string)
+	)
+	for _, r := range refs {
+		v := strings.TrimPrefix(r.Name, "refs/tags/")
+		if !strings.HasPrefix(v, prefix) {
+			continue
+		}
+		v = v[len(prefix):]
+		sv, ok := parseSemver(v)
+		if !ok || sv.build != "" || isPseudoVersion(v) {
+			continue
+		}
+		commit := r.Hash
+		if r.Peeled != "" {
+			commit = r.Peeled
+		}
+
+		major := semverMajor(v)
+		switch {
+		case m.pathMajor == "" && (major == "v0" || major == "v1"):
+		case m.pathMajor == "":
+			// v2 and up without go.mod predate modules
+			if _, gomod, err := m.goMod(ctx, commit); err != nil {
+				return nil, err
+			} else if gomod == nil {
+				incompatible[v+"+incompatible"] = commit
+			}
+			continue
+		case "/"+major != m.pathMajor:
+			continue
+		}
+		if ok, err := m.declares(ctx, commit); err != nil {
+			return nil, err
+		} else if ok {
+			vs[v] = commit
+		}
+	}
+
+	// like the go command, +incompatible versions are hidden once the module has a go.mod
+	if len(incompatible) > 0 {
+		latest := ""
+		for v := range vs {
+			if latest == "" || compareSemver(v, latest) > 0 {
+				latest = v
+			}
+		}
+		hide := false
+		if latest != "" {
+			_, gomod, err := m.goMod(ctx, vs[latest])
+			if err != nil {
+				return nil, err
+			}
+			hide = gomod != nil
+		}
+		if !hide {
+			for v, c := range incompatible {
+				vs[v] = c
+			}
+		}
+	}
+	return vs, nil
+}
+
+// sortedVersions returns the keys of vs in semver order
+func sortedVersions(vs map[string]string) []string {
+	list := make([]string, 0, len(vs))
+	for v := range vs {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return compareSemver(list[i], list[j]) < 0 })
+	return list
+}
+
+// declares checks that the go.mod of m at commit, if there is one, is for m.path
+func (m *module) declares(ctx context.Context, commit string) (bool, error) {
+	_, gomod, err := m.goMod(ctx, commit)
+	if err != nil {
+		return false, err
+	}
+	if gomod == nil {
+		return m.pathMajor == "", nil
+	}
+	return modulePath(gomod) == m.path, nil
+}
+
+// goMod finds the directory of m at commit and returns its go.mod, which is nil if there is none.
+// Modules with a major version suffix may live in a vN subdirectory.
+func (m *module) goMod(ctx context.Context, commit string) (dir string, data []byte, err error) {
+	if m.pathMajor != "" {
+		dir = path.Join(m.codeDir, m.pathMajor[1:])
+		data, err = m.readFile(ctx, commit, path.Join(dir, "go.mod"))
+		if err != nil || (data != nil && modulePath(data) == m.path) {
+			return dir, data, err
+		}
+	}
+	data, err = m.readFile(ctx, commit, path.Join(m.codeDir, "go.mod"))
+	return m.codeDir, data, err
+}
+
+// maxCachedFiles bounds fileCache, it is emptied when full
+const maxCachedFiles = 4096
+
+// fileCache keeps the files readFile found, or didn't find, by repository, commit and name.
+// Commits don't change, so listing the versions doesn't have to run git for every tag again.
+var (
+	fileCacheMu sync.Mutex
+	fileCache   = make(map[string][]byte)
+)
+
+// readFile returns the blob at name in commit, or nil if it doesn't exist
+func (m *module) readFile(ctx context.Context, commit, name string) ([]byte, error) {
+	// only full hashes name the same commit forever
+	if !isHash(commit) {
+		return mirror.ReadBlob(ctx, m.gitDir, commit, name)
+	}
+	key := m.gitDir + "\x00" + commit + "\x00" + name
+	fileCacheMu.Lock()
+	data, ok := fileCache[key]
+	fileCacheMu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, err := mirror.ReadBlob(ctx, m.gitDir, commit, name)
+	if err != nil {
+		return nil, err
+	}
+	fileCacheMu.Lock()
+	if len(fileCache) >= maxCachedFiles {
+		fileCache = make(map[string][]byte)
+	}
+	fileCache[key] = data
+	fileCacheMu.Unlock()
+	return data, nil
+}
+
+// isHash reports whether s is a full SHA-1 or SHA-256 object name
+func isHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// commitTime returns the committer date of commit
+func (m *module) commitTime(ctx context.Context, commit string) (time.Time, error) {
+	out, err := mirror.Git(ctx, m.gitDir, "log", "-1", "--format=%ct", commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "goproxy: invalid commit time")
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+// resolveCommit turns a branch, tag or (abbreviated) hash into a full commit hash
+func (m *module) resolveCommit(ctx context.Context, rev string) (string, bool) {
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return "", false
+	}
+	out, err := mirror.Git(ctx, m.gitDir, "rev-parse", "--verify", "-q", rev+"^{commit}")
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// stat resolves the version query q, which is a version, a pseudo-version or a revision
+func (m *module) stat(ctx context.Context, q string) (*revInfo, error) {
+	vs, err := m.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if commit, ok := vs[q]; ok {
+		return m.info(ctx, q, commit)
+	}
+
+	if isPseudoVersion(q) {
+		stamp, rev := pseudoVersionParts(q)
+		commit, ok := m.resolveCommit(ctx, rev)
+		if !ok || !strings.HasPrefix(commit, rev) || len(rev) != 12 {
+			return nil, errNotFound
+		}
+		info, err := m.info(ctx, q, commit)
+		if err != nil {
+			return nil, err
+		}
+		if info.Time.Format(pseudoStamp) != stamp {
+			return nil, errNotFound
+		}
+		if major := semverMajor(q); m.pathMajor != "" && "/"+major != m.pathMajor {
+			return nil, errNotFound
+		}
+		return info, nil
+	}
+	if _, ok := parseSemver(q); ok {
+		// a valid version that isn't tagged
+		return nil, errNotFound
+	}
+
+	commit, ok := m.resolveCommit(ctx, q)
+	if !ok {
+		return nil, errNotFound
+	}
+	return m.pseudo(ctx, commit, vs)
+}
+
+// latest is the highest release, or prerelease, or a pseudo-version for the default branch
+func (m *module) latest(ctx context.Context) (*revInfo, error) {
+	vs, err := m.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := sortedVersions(vs)
+	for i := len(list) - 1; i >= 0; i-- {
+		if isRelease(list[i]) {
+			return m.info(ctx, list[i], vs[list[i]])
+		}
+	}
+	if len(list) > 0 {
+		v := list[len(list)-1]
+		return m.info(ctx, v, vs[v])
+	}
+	commit, ok := m.resolveCommit(ctx, "HEAD")
+	if !ok {
+		return nil, errNotFound
+	}
+	return m.pseudo(ctx, commit, vs)
+}
+
+const pseudoStamp = "20060102150405"
+
+// pseudo returns the tagged version of commit or builds a pseudo-version for it
+func (m *module) pseudo(ctx context.Context, commit string, vs map[string]string) (*revInfo, error) {
+	out, err := mirror.Git(ctx, m.gitDir, "tag", "--merged", commit)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if m.codeDir != "" {
+		prefix = m.codeDir + "/"
+	}
+	base := ""
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		// tags of other modules in the repository, like v1.2.0 of the root for sub/, aren't a base
+		if !strings.HasPrefix(s.Text(), prefix) {
+			continue
+		}
+		v := s.Text()[len(prefix):]
+		for _, cand := range []string{v, v + "+incompatible"} {
+			c, ok := vs[cand]
+			if !ok {
+				continue
+			}
+			if c == commit {
+				return m.info(ctx, cand, commit)
+			}
+			if base == "" || compareSemver(cand, base) > 0 {
+				base = cand
+			}
+		}
+	}
+
+	t, err := m.commitTime(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	major := "v0"
+	if m.pathMajor != "" {
+		major = m.pathMajor[1:]
+	}
+	return &revInfo{
+		Version: pseudoVersion(major, base, t.Format(pseudoStamp), commit),
+		Time:    t,
+		commit:  commit,
+	}, nil
+}
+
+func (m *module) info(ctx context.Context, version, commit string) (*revInfo, error) {
+	t, err := m.commitTime(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	return &revInfo{Version: version, Time: t, commit: commit}, nil
+}
+
+// modulePath returns the path declared in a go.mod file
+func modulePath(gomod []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(gomod))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if !strings.HasPrefix(line, "module") {
+			continue
+		}
+		p := strings.TrimSpace(strings.TrimPrefix(line, "module"))
+		if u, err := strconv.Unquote(p); err == nil {
+			p = u
+		}
+		return p
+	}
+	return ""
+}
+
+// goVersion returns the version of the go directive in gomod, or "" if there is none or it's invalid.
+// Like the go command it fixes up versions like 1.21.x to their language version.
+func goVersion(gomod []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(gomod))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 || f[0] != "go" {
+			continue
+		}
+		if goVersionRE.MatchString(f[1]) {
+			return f[1]
+		}
+		if sub := laxGoVersionRE.FindStringSubmatch(f[1]); sub != nil {
+			return sub[1]
+		}
+		return ""
+	}
+	return ""
+}
+
+// the go directive versions golang.org/x/mod/modfile accepts
+var (
+	goVersionRE    = regexp.MustCompile(`^([1-9][0-9]*)\.(0|[1-9][0-9]*)(\.(0|[1-9][0-9]*))?([a-z]+[0-9]+)?$`)
+	laxGoVersionRE = regexp.MustCompile(`^v?(([1-9][0-9]*)\.(0|[1-9][0-9]*))([^0-9].*)$`)
+)
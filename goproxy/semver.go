@@ -0,0 +1,198 @@
+package goproxy
+
+import (
+	"regexp"
+	"strings"
+)
+
+// semver is a parsed version like v1.2.3-pre+incompatible
+type semver struct {
+	major, minor, patch string
+	prerelease          string // including the leading -
+	build               string // including the leading +
+}
+
+// parseSemver accepts canonical versions only, like the go command does for tags
+func parseSemver(v string) (sv semver, ok bool) {
+	if !strings.HasPrefix(v, "v") {
+		return sv, false
+	}
+	v = v[1:]
+	if i := strings.Index(v, "+"); i >= 0 {
+		sv.build, v = v[i:], v[:i]
+		if !validIdents(sv.build[1:], false) {
+			return sv, false
+		}
+	}
+	if i := strings.Index(v, "-"); i >= 0 {
+		sv.prerelease, v = v[i:], v[:i]
+		if !validIdents(sv.prerelease[1:], true) {
+			return sv, false
+		}
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return sv, false
+	}
+	for _, p := range parts {
+		if !isNum(p) || (len(p) > 1 && p[0] == '0') {
+			return sv, false
+		}
+	}
+	sv.major, sv.minor, sv.patch = parts[0], parts[1], parts[2]
+	return sv, true
+}
+
+func validIdents(s string, noLeadingZero bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+		if noLeadingZero && isNum(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNum(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// semverMajor returns v0, v1, v2... for a valid version
+func semverMajor(v string) string {
+	sv, _ := parseSemver(v)
+	return "v" + sv.major
+}
+
+// isRelease is true for versions without a prerelease
+func isRelease(v string) bool {
+	sv, ok := parseSemver(v)
+	return ok && sv.prerelease == ""
+}
+
+// compareSemver orders versions by semver precedence, build metadata is ignored
+func compareSemver(a, b string) int {
+	x, _ := parseSemver(a)
+	y, _ := parseSemver(b)
+	if c := compareNum(x.major, y.major); c != 0 {
+		return c
+	}
+	if c := compareNum(x.minor, y.minor); c != 0 {
+		return c
+	}
+	if c := compareNum(x.patch, y.patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(x.prerelease, y.prerelease)
+}
+
+func compareNum(a, b string) int {
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a[1:], "."), strings.Split(b[1:], ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, bn := isNum(as[i]), isNum(bs[i])
+		switch {
+		case an && bn:
+			return compareNum(as[i], bs[i])
+		case an:
+			return -1
+		case bn:
+			return 1
+		default:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+var pseudoVersionRE = regexp.MustCompile(`^v[0-9]+\.(0\.0-|\d+\.\d+-([^+]*\.)?0\.)\d{14}-[A-Za-z0-9]+(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// isPseudoVersion reports whether v looks like v0.0.0-20060102150405-abcdefabcdef
+func isPseudoVersion(v string) bool {
+	return strings.Count(v, "-") >= 2 && pseudoVersionRE.MatchString(v)
+}
+
+// pseudoVersionParts returns the timestamp and the abbreviated commit hash of a pseudo-version
+func pseudoVersionParts(v string) (stamp, rev string) {
+	v = strings.TrimSuffix(v, "+incompatible")
+	i := strings.LastIndex(v, "-")
+	rev, v = v[i+1:], v[:i]
+	j := strings.LastIndexAny(v, "-.")
+	return v[j+1:], rev
+}
+
+// pseudoVersion builds the version the go command uses for an untagged commit, based on the closest tag before it
+func pseudoVersion(major, base, stamp, rev string) string {
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	var build string
+	if strings.HasSuffix(base, "+incompatible") {
+		base, build = strings.TrimSuffix(base, "+incompatible"), "+incompatible"
+	}
+	sv, ok := parseSemver(base)
+	switch {
+	case !ok:
+		if major == "" {
+			major = "v0"
+		}
+		return major + ".0.0-" + stamp + "-" + rev
+	case sv.prerelease != "":
+		return base + ".0." + stamp + "-" + rev + build
+	default:
+		return "v" + sv.major + "." + sv.minor + "." + incDecimal(sv.patch) + "-0." + stamp + "-" + rev + build
+	}
+}
+
+// incDecimal adds one to the decimal number s of any length
+func incDecimal(s string) string {
+	b := []byte(s)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '9' {
+			b[i]++
+			return string(b)
+		}
+		b[i] = '0'
+	}
+	return "1" + string(b)
+}
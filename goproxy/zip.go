@@ -0,0 +1,278 @@
+package goproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/mirror"
+)
+
+// writeZip writes the module zip of m at commit. It follows the rules of the go command
+// (golang.org/x/mod/zip), so the hashes in go.sum match the ones of a direct download:
+//
+// - the files come from git archive, with export-ignore and export-subst turned off
+// - nested modules, vendored packages (by the rules of the go version in go.mod) and anything but regular files are left out
+// - a module in a subdirectory without a LICENSE gets the one of the repository root
+func (m *module) writeZip(ctx context.Context, w io.Writer, version, commit string) error {
+	dir, gomod, err := m.goMod(ctx, commit)
+	if err != nil {
+		return err
+	}
+	goVers := goVersion(gomod)
+
+	if err := ensureGitAttributes(m.gitDir); err != nil {
+		return err
+	}
+
+	// directories with a go.mod of their own, in any case, are other modules
+	out, err := mirror.Git(ctx, m.gitDir, "ls-tree", "-r", "-z", commit)
+	if err != nil {
+		return err
+	}
+	var nested []string
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> TAB <name>
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 || !strings.HasPrefix(entry, "100") {
+			continue
+		}
+		rel, ok := relTo(dir, entry[tab+1:])
+		if sub, base := path.Split(rel); ok && sub != "" && strings.EqualFold(base, "go.mod") {
+			nested = append(nested, sub)
+		}
+	}
+
+	args := []string{"-c", "core.autocrlf=input", "-c", "core.eol=lf", "archive", "--format=tar", commit}
+	if dir != "" {
+		args = append(args, "--", dir)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = m.gitDir
+	cmd.Stderr = &stderr
+	archive, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "goproxy: archive pipe failed")
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "goproxy: archive failed")
+	}
+	waited := false
+	defer func() {
+		// stop git if we bail out early
+		if !waited {
+			cancel()
+			cmd.Wait()
+		}
+	}()
+
+	var (
+		zw          = zip.NewWriter(w)
+		prefix      = m.path + "@" + version + "/"
+		seen        = make(map[string]string)
+		haveLicense bool
+	)
+	add := func(name string, r io.Reader) error {
+		if err := checkFilePath(name); err != nil {
+			return errors.Wrapf(err, "goproxy: file %q", name)
+		}
+		if strings.ToLower(name) == "go.mod" && name != "go.mod" {
+			return errors.Errorf("goproxy: file %q must be named go.mod", name)
+		}
+		folded := strings.ToLower(name)
+		if other, dup := seen[folded]; dup {
+			return errors.Errorf("goproxy: files %q and %q only differ in case", other, name)
+		}
+		seen[folded] = name
+		f, err := zw.Create(prefix + name)
+		if err != nil {
+			return errors.Wrap(err, "goproxy: zip failed")
+		}
+		_, err = io.Copy(f, r)
+		return errors.Wrap(err, "goproxy: zip failed")
+	}
+
+	tr := tar.NewReader(archive)
+files:
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "goproxy: reading archive failed: %s", stderr.String())
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name, ok := relTo(dir, hdr.Name)
+		if !ok || isVendoredPackage(name, goVers) {
+			continue
+		}
+		for _, n := range nested {
+			if strings.HasPrefix(name, n) {
+				continue files
+			}
+		}
+		if name == ".hg_archival.txt" {
+			// the go command drops it for any VCS
+			continue
+		}
+		if name == "LICENSE" {
+			haveLicense = true
+		}
+		if err := add(name, tr); err != nil {
+			return err
+		}
+	}
+	waited = true
+	if err := cmd.Wait(); err != nil {
+		return errors.Wrapf(err, "goproxy: archive failed: %s", stderr.String())
+	}
+
+	if dir != "" && !haveLicense {
+		license, err := m.readFile(ctx, commit, "LICENSE")
+		if err != nil {
+			return err
+		}
+		if license != nil {
+			if err := add("LICENSE", bytes.NewReader(license)); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.Wrap(zw.Close(), "goproxy: zip failed")
+}
+
+// ensureGitAttributes disables the attributes that make git archive depend on the git version, like the go command does in its own clones
+func ensureGitAttributes(gitDir string) error {
+	const attr = "\n* -export-subst -export-ignore\n"
+	file := filepath.Join(gitDir, "info", "attributes")
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "goproxy: reading attributes failed")
+	}
+	if bytes.Contains(data, []byte(attr)) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return errors.Wrap(err, "goproxy: writing attributes failed")
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "goproxy: writing attributes failed")
+	}
+	_, err = f.WriteString(attr)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Wrap(err, "goproxy: writing attributes failed")
+}
+
+// relTo returns name relative to dir, if it's inside of it
+func relTo(dir, name string) (string, bool) {
+	if dir == "" {
+		return name, name != ""
+	}
+	if !strings.HasPrefix(name, dir+"/") {
+		return "", false
+	}
+	return name[len(dir)+1:], len(name) > len(dir)+1
+}
+
+// isVendoredPackage matches files of packages in vendor directories. For go 1.24 and later
+// that includes vendor/modules.txt, and vendor/ inside of a vendored path is matched right;
+// older versions keep the behavior they had, so their hashes stay the same.
+func isVendoredPackage(name, goVers string) bool {
+	go124 := goLangAtLeast(goVers, 24)
+	if go124 && name == "vendor/modules.txt" {
+		return true
+	}
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i += len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		if go124 {
+			i = j + len("/vendor/")
+		} else {
+			// golang.org/issue/37397
+			i += len("/vendor/")
+		}
+	} else {
+		return false
+	}
+	return strings.Contains(name[i:], "/")
+}
+
+// goLangAtLeast reports whether the language version of the go directive v is at least 1.minor.
+// A missing or invalid version is older than any.
+func goLangAtLeast(v string, minor int) bool {
+	sub := goVersionRE.FindStringSubmatch(v)
+	if sub == nil {
+		return false
+	}
+	if sub[1] != "1" {
+		return true
+	}
+	n, err := strconv.Atoi(sub[2])
+	return err == nil && n >= minor
+}
+
+// checkFilePath applies the rules for file names in module zips
+func checkFilePath(name string) error {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.Count(elem, ".") == len(elem) {
+			return errors.New("invalid path element")
+		}
+		if strings.HasSuffix(elem, ".") {
+			return errors.New("trailing dot in path element")
+		}
+		for _, r := range elem {
+			if !fileNameOK(r) {
+				return errors.Errorf("invalid char %q", r)
+			}
+		}
+		short := elem
+		if i := strings.Index(short, "."); i >= 0 {
+			short = short[:i]
+		}
+		for _, bad := range badWindowsNames {
+			if strings.EqualFold(bad, short) {
+				return errors.Errorf("%q is a disallowed name on Windows", short)
+			}
+		}
+		if tilde := strings.LastIndexByte(short, '~'); tilde >= 0 && tilde < len(short)-1 && isNum(short[tilde+1:]) {
+			return errors.New("trailing tilde and digits in path element")
+		}
+	}
+	return nil
+}
+
+func fileNameOK(r rune) bool {
+	if r < utf8.RuneSelf {
+		const allowed = "!#$%&()+,-.=@[]^_{}~ "
+		return '0' <= r && r <= '9' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || strings.ContainsRune(allowed, r)
+	}
+	return unicode.IsLetter(r)
+}
+
+var badWindowsNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
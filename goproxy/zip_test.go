@@ -0,0 +1,186 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// zipFixture is a mirror with a module at the root and one in sub/:
+//
+// - v1.0.0 and sub/v1.0.0 declare go 1.21
+// - v1.1.0 declares go 1.24, which changes what counts as vendored
+var zipFixture = map[string]string{
+	"go.mod":                      "module example.com/x/px\n\ngo 1.21\n",
+	"px.go":                       "package px\n",
+	"LICENSE":                     "license of px\n",
+	".hg_archival.txt":            "repo: 0000\n",
+	"vendor/modules.txt":          "# example.com/dep v1.0.0\n",
+	"vendor/example.com/dep/d.go": "package dep\n",
+	"vendor/vendor.go":            "package vendor\n",
+	"internal/vendor/vendor.go":   "package vendor\n",
+	"internal/vendor/pkg/p.go":    "package pkg\n",
+	"nested/Go.Mod":               "module example.com/x/px/nested\n",
+	"nested/n.go":                 "package nested\n",
+	"sub/go.mod":                  "module example.com/x/px/sub\n\ngo 1.21\n",
+	"sub/sub.go":                  "package sub\n",
+	"sub/vendor/modules.txt":      "# nothing\n",
+}
+
+func newZipFixture(t *testing.T) string {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = work
+		cmd.Env = append(os.Environ(),
+			"HOME="+dir,
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		file := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "--quiet")
+	for name, content := range zipFixture {
+		write(name, content)
+	}
+	if err := os.Symlink("px.go", filepath.Join(work, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "go 1.21")
+	git("tag", "v1.0.0")
+	git("tag", "sub/v1.0.0")
+
+	write("go.mod", "module example.com/x/px\n\ngo 1.24.0\n")
+	git("commit", "--quiet", "-am", "go 1.24")
+	git("tag", "v1.1.0")
+
+	gitDir := filepath.Join(dir, "px.git")
+	git("clone", "--quiet", "--bare", work, gitDir)
+	return gitDir
+}
+
+// the sums are the ones of golang.org/x/mod/zip.CreateFromVCS for the same tags
+func TestWriteZipHash(t *testing.T) {
+	gitDir := newZipFixture(t)
+
+	for _, tc := range []struct {
+		path, codeDir, version string
+		files                  []string
+		sum                    string
+	}{
+		{
+			path: "example.com/x/px", version: "v1.0.0",
+			files: []string{"LICENSE", "go.mod", "px.go", "vendor/modules.txt", "vendor/vendor.go"},
+			sum:   "h1:nLY5nhlJ8TlmbSGyo0p+j8v+NRcEvsmf6n6Y7TSmqOo=",
+		},
+		{
+			path: "example.com/x/px", version: "v1.1.0",
+			files: []string{"LICENSE", "go.mod", "internal/vendor/vendor.go", "px.go", "vendor/vendor.go"},
+			sum:   "h1:L54qNRa9czgGC5g/jXXHa+F6FHAKLXrulS8Fz9IPWJo=",
+		},
+		{
+			path: "example.com/x/px/sub", codeDir: "sub", version: "v1.0.0",
+			files: []string{"LICENSE", "go.mod", "sub.go", "vendor/modules.txt"},
+			sum:   "h1:cRGIRc+jgXGZFvNCCUz0b2RiWsGHnZk/2A+nYQFSzA0=",
+		},
+	} {
+		t.Run(tc.path+"@"+tc.version, func(t *testing.T) {
+			m := &module{path: tc.path, gitDir: gitDir, codeDir: tc.codeDir}
+			tag := tc.version
+			if tc.codeDir != "" {
+				tag = tc.codeDir + "/" + tag
+			}
+			var buf bytes.Buffer
+			if err := m.writeZip(context.Background(), &buf, tc.version, tag); err != nil {
+				t.Fatal(err)
+			}
+
+			files, sum, err := hashZip(buf.Bytes(), tc.path+"@"+tc.version+"/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.Join(files, " "), strings.Join(tc.files, " "); got != want {
+				t.Errorf("files are %s, want %s", got, want)
+			}
+			if sum != tc.sum {
+				t.Errorf("sum is %s, want %s", sum, tc.sum)
+			}
+		})
+	}
+}
+
+func TestWriteZipGoModCase(t *testing.T) {
+	gitDir := newZipFixture(t)
+
+	// nested/Go.Mod makes nested/ a module of its own, which can't be named like that
+	m := &module{path: "example.com/x/px/nested", gitDir: gitDir, codeDir: "nested"}
+	err := m.writeZip(context.Background(), ioutil.Discard, "v1.0.0", "v1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "must be named go.mod") {
+		t.Errorf("writeZip returned %v, want an error about the name of go.mod", err)
+	}
+}
+
+// hashZip returns the names in a module zip without prefix and its h1: hash, like go.sum has it
+func hashZip(data []byte, prefix string) ([]string, string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", err
+	}
+	// dirhash.Hash1 sorts by name
+	files := append([]*zip.File(nil), zr.File...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	var (
+		names []string
+		lines strings.Builder
+	)
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name, prefix) {
+			return nil, "", fmt.Errorf("file %q is outside of %s", f.Name, prefix)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		names = append(names, strings.TrimPrefix(f.Name, prefix))
+		fmt.Fprintf(&lines, "%x  %s\n", h.Sum(nil), f.Name)
+	}
+	sum := sha256.Sum256([]byte(lines.String()))
+	return names, "h1:" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"html/template"
@@ -25,7 +26,12 @@ import (
 	"github.com/cryptix/synchrotron/config/i18n"
 	"github.com/cryptix/synchrotron/config/routes"
 	"github.com/cryptix/synchrotron/config/utils"
+	"github.com/cryptix/synchrotron/db"
 	_ "github.com/cryptix/synchrotron/db/migrations"
+	"github.com/cryptix/synchrotron/gitserver"
+	_ "github.com/cryptix/synchrotron/mirror/ipfs"
+	_ "github.com/cryptix/synchrotron/mirror/ssb"
+	"github.com/cryptix/synchrotron/mirror/static"
 	"github.com/cryptix/synchrotron/models"
 )
 
@@ -39,6 +45,7 @@ var (
 func main() {
 	cmdLine := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	compileTemplate := cmdLine.Bool("compile-templates", false, "Compile Templates")
+	exportStatic := cmdLine.Bool("export-static", false, "Export all public mirrors for dumb HTTP to the static dir and exit")
 	cmdLine.Parse(os.Args[1:])
 
 	// create timestamped logfile
@@ -53,6 +60,9 @@ func main() {
 	logging.SetupLogging(io.MultiWriter(os.Stderr, logFile))
 	log = logging.Logger("synchroserv")
 
+	// shared by all git transports, create it before the router so it doesn't log as http
+	gitSrv := routes.GitServer(log)
+
 	mux := http.NewServeMux()
 	mux.Handle("/", routes.Router(kitlog.With(log, "unit", "http")))
 	admin.Admin.MountTo("/admin", mux)
@@ -99,13 +109,47 @@ func main() {
 		return funcMap
 	}
 
-	h := logging.InjectHandler(kitlog.With(log, "unit", "http"))(middlewares.Apply(mux))
+	h := logging.InjectHandler(kitlog.With(log, "unit", "http"))(utils.LaxCookies(middlewares.Apply(mux)))
 	h = logging.RecoveryHandler()(h)
 
 	if *compileTemplate {
 		bindatafs.AssetFS.Compile()
 		return
 	}
+	if *exportStatic {
+		err := static.ExportAll(context.Background(), db.DB, config.Config.Static.Dir, kitlog.With(log, "unit", "static"))
+		check(err)
+		return
+	}
+	admin.JobQueue.Start(context.Background(), kitlog.With(log, "unit", "queue"))
+	go admin.StartPoller(context.Background(), kitlog.With(log, "unit", "poller"))
+	go admin.StartWebhookSender(context.Background(), kitlog.With(log, "unit", "webhooks"))
+
+	if port := config.Config.GitDaemon.Port; port != 0 {
+		d := gitserver.Daemon{
+			Server: gitSrv,
+			Addr:   fmt.Sprintf(":%d", port),
+		}
+		go func() {
+			err := d.ListenAndServe(context.Background())
+			check(err)
+		}()
+	}
+
+	if port := config.Config.SSH.Port; port != 0 {
+		hostKey, err := gitserver.LoadHostKey(config.Config.SSH.HostKey)
+		check(err)
+		s := gitserver.SSHServer{
+			Server:  gitSrv,
+			Addr:    fmt.Sprintf(":%d", port),
+			HostKey: hostKey,
+		}
+		go func() {
+			err := s.ListenAndServe(context.Background())
+			check(err)
+		}()
+	}
+
 	addr := fmt.Sprintf(":%d", config.Config.Port)
 	log.Log("event", "listening", "addr", addr)
 	if err := http.ListenAndServe(addr, h); err != nil {
@@ -0,0 +1,65 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// ErrEmptyBundle is returned when there are no new objects to put into a bundle
+var ErrEmptyBundle = errors.New("mirror: nothing to bundle")
+
+// FullBundle writes every ref of repo into the bundle file, it can be cloned from
+func (s *Store) FullBundle(ctx context.Context, repo *models.Repository, file string) error {
+	return s.bundle(ctx, repo, file, "--all")
+}
+
+// IncrementalBundle writes the refs that moved in res into the bundle file.
+// It only holds the objects that are new since the refs were last seen.
+func (s *Store) IncrementalBundle(ctx context.Context, repo *models.Repository, res *Result, file string) error {
+	var (
+		args    []string
+		updated = make(map[string]bool)
+	)
+	for _, u := range res.Updates {
+		updated[u.Name] = true
+		if u.New != "" {
+			args = append(args, u.Name)
+		}
+		if u.Old != "" {
+			args = append(args, "^"+u.Old)
+		}
+	}
+	if len(args) == 0 {
+		return ErrEmptyBundle
+	}
+	// the receiver already has everything the unchanged refs point to
+	for _, r := range res.Refs {
+		if !updated[r.Name] {
+			args = append(args, "^"+r.Hash)
+		}
+	}
+	return s.bundle(ctx, repo, file, args...)
+}
+
+func (s *Store) bundle(ctx context.Context, repo *models.Repository, file string, revs ...string) error {
+	path := s.Path(repo)
+	unlock := s.lock(path)
+	defer unlock()
+
+	// write next to the target so downloads never see a partial file
+	tmp := file + ".tmp"
+	_, err := git(ctx, path, append([]string{"bundle", "create", tmp}, revs...)...)
+	if err != nil {
+		os.Remove(tmp)
+		if strings.Contains(err.Error(), "empty bundle") {
+			return ErrEmptyBundle
+		}
+		return err
+	}
+	return errors.Wrap(os.Rename(tmp, file), "mirror: moving bundle into place failed")
+}
@@ -0,0 +1,135 @@
+package mirror
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// Signature is the author or committer of a Commit
+type Signature struct {
+	Name, Email string
+	When        time.Time
+}
+
+// Commit is what the mirror knows about one commit
+type Commit struct {
+	Hash, Tree string
+	Parents    []string
+	Author     Signature
+	Committer  Signature
+	Message    string
+
+	// Files are the changes against the first parent, only filled in by Store.Commit
+	Files []FileChange
+}
+
+// FileChange is one file of a commit, Additions and Deletions are zero for binary files
+type FileChange struct {
+	Name string
+	// Status is added, modified, removed or changed for type changes
+	Status    string
+	Additions int
+	Deletions int
+}
+
+// commitFormat puts the fields of a commit on separate lines, the message comes last
+const commitFormat = "%H%n%T%n%P%n%an%n%ae%n%aI%n%cn%n%ce%n%cI%n%B"
+
+// Commit looks up rev in the mirror for repo, it returns nil if there is no such commit
+func (s *Store) Commit(ctx context.Context, repo *models.Repository, rev string) (*Commit, error) {
+	// rev ends up on the command line, it must not look like an option
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return nil, nil
+	}
+	path := s.Path(repo)
+	hash, err := git(ctx, path, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		// --quiet fails without a message for revisions that don't exist
+		return nil, nil
+	}
+	out, err := git(ctx, path, "show", "-s", "--format="+commitFormat, hash)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitN(out, "\n", 10)
+	if len(lines) < 9 {
+		return nil, errors.Errorf("mirror: unexpected output of git show for %s", hash)
+	}
+	c := &Commit{
+		Hash:      lines[0],
+		Tree:      lines[1],
+		Parents:   strings.Fields(lines[2]),
+		Author:    signature(lines[3], lines[4], lines[5]),
+		Committer: signature(lines[6], lines[7], lines[8]),
+	}
+	if len(lines) == 10 {
+		c.Message = lines[9]
+	}
+
+	args := []string{"diff-tree", "-r", "--no-commit-id", "--numstat", "--root", c.Hash}
+	if len(c.Parents) > 0 {
+		args = []string{"diff-tree", "-r", "--no-commit-id", "--numstat", c.Parents[0], c.Hash}
+	}
+	numstat, err := git(ctx, path, args...)
+	if err != nil {
+		return nil, err
+	}
+	args[3] = "--name-status"
+	nameStatus, err := git(ctx, path, args...)
+	if err != nil {
+		return nil, err
+	}
+	c.Files = fileChanges(numstat, nameStatus)
+	return c, nil
+}
+
+// DefaultBranch returns the branch HEAD of the mirror for repo points to, empty if it's detached
+func (s *Store) DefaultBranch(ctx context.Context, repo *models.Repository) (string, error) {
+	out, err := git(ctx, s.Path(repo), "symbolic-ref", "--quiet", "HEAD")
+	if err != nil {
+		return "", nil
+	}
+	return strings.TrimPrefix(out, "refs/heads/"), nil
+}
+
+func signature(name, email, when string) Signature {
+	t, _ := time.Parse(time.RFC3339, when)
+	return Signature{Name: name, Email: email, When: t}
+}
+
+// fileChanges merges the --numstat and --name-status output of git diff-tree, both list the files in the same order
+func fileChanges(numstat, nameStatus string) []FileChange {
+	var files []FileChange
+	for _, line := range strings.Split(nameStatus, "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		status := "modified"
+		switch fields[0] {
+		case "A":
+			status = "added"
+		case "D":
+			status = "removed"
+		case "T":
+			status = "changed"
+		}
+		files = append(files, FileChange{Name: fields[1], Status: status})
+	}
+	for i, line := range strings.Split(numstat, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || i >= len(files) {
+			continue
+		}
+		// binary files have - instead of counts
+		files[i].Additions, _ = strconv.Atoi(fields[0])
+		files[i].Deletions, _ = strconv.Atoi(fields[1])
+	}
+	return files
+}
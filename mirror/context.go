@@ -0,0 +1,18 @@
+package mirror
+
+import "context"
+
+type jobIDKeyT string
+
+var jobIDKey jobIDKeyT = "mirrorJobID"
+
+// WithJobID attaches the id of the job doing a fetch to ctx, Sync records it with every ref update
+func WithJobID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobIDKey, id)
+}
+
+// JobIDFromContext returns the job id set by WithJobID
+func JobIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(jobIDKey).(string)
+	return id
+}
@@ -0,0 +1,191 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// Ref is a named object in a mirror
+type Ref struct {
+	Name, Hash string
+
+	// Peeled is the object an annotated tag points to
+	Peeled string
+}
+
+// RefUpdate describes how a ref moved during a fetch.
+// Old is empty for new refs and New is empty for deleted ones.
+type RefUpdate struct {
+	Name, Old, New string
+}
+
+// Result summarizes one fetch
+type Result struct {
+	Cloned  bool
+	Updates []RefUpdate
+	Refs    []Ref
+
+	// History holds the rows Sync recorded for Updates
+	History []models.RefUpdate
+}
+
+var refSpecs = []string{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// Fetch clones repo into the store the first time and fetches incrementally after that.
+func (s *Store) Fetch(ctx context.Context, repo *models.Repository) (*Result, error) {
+	if repo.URL == "" {
+		return nil, errors.Errorf("mirror: repository %q has no URL", repo.Name)
+	}
+	path := s.Path(repo)
+	unlock := s.lock(path)
+	defer unlock()
+
+	var res Result
+	if !s.Exists(repo) {
+		if err := initMirror(ctx, path, repo.URL); err != nil {
+			os.RemoveAll(path)
+			return nil, err
+		}
+		res.Cloned = true
+	} else if _, err := git(ctx, path, "remote", "set-url", "origin", repo.URL); err != nil {
+		return nil, err
+	}
+
+	before, err := ListRefs(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := git(ctx, path, "fetch", "--prune", "--quiet", "origin"); err != nil {
+		if res.Cloned {
+			os.RemoveAll(path)
+		}
+		return nil, err
+	}
+
+	if err := updateHEAD(ctx, path); err != nil {
+		return nil, err
+	}
+
+	res.Refs, err = ListRefs(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	res.Updates = diffRefs(before, res.Refs)
+	return &res, nil
+}
+
+// Refs lists the refs of the mirror for repo that start with one of prefixes (all if none are given)
+func (s *Store) Refs(ctx context.Context, repo *models.Repository, prefixes ...string) ([]Ref, error) {
+	return ListRefs(ctx, s.Path(repo), prefixes...)
+}
+
+func initMirror(ctx context.Context, path, url string) error {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return errors.Wrap(err, "mirror: failed to create directory")
+	}
+	if _, err := git(ctx, path, "init", "--bare", "--quiet"); err != nil {
+		return err
+	}
+	if _, err := git(ctx, path, "remote", "add", "origin", url); err != nil {
+		return err
+	}
+	if _, err := git(ctx, path, "config", "--unset-all", "remote.origin.fetch"); err != nil {
+		return err
+	}
+	for _, spec := range refSpecs {
+		if _, err := git(ctx, path, "config", "--add", "remote.origin.fetch", spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateHEAD points HEAD of the mirror at the default branch of the upstream
+func updateHEAD(ctx context.Context, path string) error {
+	out, err := git(ctx, path, "ls-remote", "--symref", "origin", "HEAD")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, "ref: ") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "ref: "))
+		if len(fields) == 2 && fields[1] == "HEAD" && strings.HasPrefix(fields[0], "refs/heads/") {
+			_, err = git(ctx, path, "symbolic-ref", "HEAD", fields[0])
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRefs lists the refs of the repository at path, like Store.Refs
+func ListRefs(ctx context.Context, path string, prefixes ...string) ([]Ref, error) {
+	args := append([]string{"for-each-ref", "--format=%(objectname) %(refname) %(*objectname)"}, prefixes...)
+	out, err := git(ctx, path, args...)
+	if err != nil {
+		return nil, err
+	}
+	var refs []Ref
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		r := Ref{Name: fields[1], Hash: fields[0]}
+		if len(fields) > 2 {
+			r.Peeled = fields[2]
+		}
+		refs = append(refs, r)
+	}
+	return refs, nil
+}
+
+func diffRefs(before, after []Ref) []RefUpdate {
+	old := make(map[string]string, len(before))
+	for _, r := range before {
+		old[r.Name] = r.Hash
+	}
+	var updates []RefUpdate
+	for _, r := range after {
+		if h, ok := old[r.Name]; !ok || h != r.Hash {
+			updates = append(updates, RefUpdate{Name: r.Name, Old: h, New: r.Hash})
+		}
+		delete(old, r.Name)
+	}
+	for _, r := range before {
+		if h, ok := old[r.Name]; ok {
+			updates = append(updates, RefUpdate{Name: r.Name, Old: h})
+		}
+	}
+	return updates
+}
+
+// ReadFile returns the file at name in rev of the mirror for repo, or nil if there is none
+func (s *Store) ReadFile(ctx context.Context, repo *models.Repository, rev, name string) ([]byte, error) {
+	return ReadBlob(ctx, s.Path(repo), rev, name)
+}
+
+// ReadBlob returns the file at name in rev of the repository at dir, or nil if there is none.
+// Directories and symlinks count as none.
+func ReadBlob(ctx context.Context, dir, rev, name string) ([]byte, error) {
+	out, err := git(ctx, dir, "ls-tree", "-z", rev, "--", name)
+	if err != nil {
+		return nil, err
+	}
+	// <mode> blob <hash>\t<name>
+	fields := strings.Fields(strings.SplitN(out, "\t", 2)[0])
+	if len(fields) != 3 || fields[1] != "blob" || fields[0] == "120000" {
+		return nil, nil
+	}
+	return Git(ctx, dir, "cat-file", "blob", fields[2])
+}
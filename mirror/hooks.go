@@ -0,0 +1,47 @@
+package mirror
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// Hook is run after a successful Sync
+type Hook func(ctx context.Context, tx *gorm.DB, repo *models.Repository, res *Result) error
+
+type namedHook struct {
+	name string
+	fn   Hook
+}
+
+var (
+	hooksMu sync.Mutex
+	hooks   []namedHook
+)
+
+// RegisterHook adds fn to the hooks run by RunHooks, name is used in errors
+func RegisterHook(name string, fn Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, namedHook{name, fn})
+}
+
+// RunHooks calls every registered hook, a failing hook doesn't stop the others
+func RunHooks(ctx context.Context, tx *gorm.DB, repo *models.Repository, res *Result) []error {
+	hooksMu.Lock()
+	hs := make([]namedHook, len(hooks))
+	copy(hs, hooks)
+	hooksMu.Unlock()
+
+	var errs []error
+	for _, h := range hs {
+		if err := h.fn(ctx, tx, repo, res); err != nil {
+			errs = append(errs, errors.Wrap(err, h.name))
+		}
+	}
+	return errs
+}
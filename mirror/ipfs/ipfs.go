@@ -0,0 +1,238 @@
+// Package ipfs is a mirror target that adds the mirrors to IPFS through the HTTP API of a daemon.
+//
+// The mirror is added as a directory prepared for git's dumb HTTP transport,
+// so every IPFS gateway can serve clones: git clone http://127.0.0.1:8080/ipfs/<cid>
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// DefaultAddress is used for targets without an address
+const DefaultAddress = "http://127.0.0.1:5001"
+
+func init() {
+	mirror.RegisterTarget("ipfs", func(t models.Target) (mirror.MirrorTarget, error) {
+		return New(t.Address)
+	})
+}
+
+// Target talks to the API of an IPFS daemon
+type Target struct {
+	api    *url.URL
+	client *http.Client
+}
+
+// New returns a target for the daemon API at addr
+func New(addr string) (*Target, error) {
+	if addr == "" {
+		addr = DefaultAddress
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "ipfs: invalid api address")
+	}
+	return &Target{api: u, client: http.DefaultClient}, nil
+}
+
+// PublishObjects adds the mirror at path to IPFS and keeps the root CID in state.
+// The root it replaces is unpinned, the daemon may collect what only it referenced.
+func (t *Target) PublishObjects(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, path string) error {
+	// dumb clients need info/refs and objects/info/packs
+	cmd := exec.CommandContext(ctx, "git", "update-server-info")
+	cmd.Dir = path
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "ipfs: update-server-info failed: %s", out)
+	}
+
+	cid, err := t.addDir(ctx, path)
+	if err != nil {
+		return err
+	}
+	old := state.IPFSCID
+	state.IPFSCID = cid
+	if old == "" || old == cid {
+		return nil
+	}
+	return t.unpin(ctx, old)
+}
+
+// PublishRefs has nothing left to do, the root of PublishObjects has the refs as well
+func (t *Target) PublishRefs(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, updates []models.RefUpdate) error {
+	if state.IPFSCID == "" {
+		return errors.New("ipfs: objects were not published")
+	}
+	return nil
+}
+
+// Status asks the daemon for its peer id
+func (t *Target) Status(ctx context.Context) (string, error) {
+	var id struct {
+		ID           string
+		AgentVersion string
+	}
+	resp, err := t.call(ctx, "id", nil, "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+		return "", errors.Wrap(err, "ipfs: invalid id response")
+	}
+	return fmt.Sprintf("online as %s (%s)", id.ID, id.AgentVersion), nil
+}
+
+// addDir adds the directory tree at root and returns its CID
+func (t *Target) addDir(ctx context.Context, root string) (string, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeTree(mw, root))
+	}()
+
+	params := url.Values{
+		"recursive": {"true"},
+		"pin":       {"true"},
+	}
+	resp, err := t.call(ctx, "add", params, mw.FormDataContentType(), pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// one object per added file and directory
+	var (
+		base = filepath.Base(root)
+		cid  string
+		dec  = json.NewDecoder(resp.Body)
+	)
+	for {
+		var obj struct{ Name, Hash string }
+		if err := dec.Decode(&obj); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", errors.Wrap(err, "ipfs: invalid add response")
+		}
+		if obj.Name == base {
+			cid = obj.Hash
+		}
+	}
+	if cid == "" {
+		return "", errors.New("ipfs: add response is missing the root directory")
+	}
+	return cid, nil
+}
+
+// unpin removes the recursive pin of cid, it's fine if there is none anymore
+func (t *Target) unpin(ctx context.Context, cid string) error {
+	resp, err := t.call(ctx, "pin/rm", url.Values{"arg": {cid}, "recursive": {"true"}}, "", nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "not pinned") {
+			return nil
+		}
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// skip lists local bookkeeping of a mirror that clones don't need.
+// config holds the upstream URL with any credentials in it, it must never be published.
+var skip = map[string]bool{
+	"config":       true,
+	"description":  true,
+	"hooks":        true,
+	"logs":         true,
+	"index":        true,
+	"FETCH_HEAD":   true,
+	"ORIG_HEAD":    true,
+	"info/exclude": true,
+}
+
+// writeTree writes every directory and file below root as a part of mw
+func writeTree(mw *multipart.Writer, root string) error {
+	base := filepath.Base(root)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if skip[filepath.ToSlash(rel)] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := filepath.ToSlash(filepath.Join(base, rel))
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, url.QueryEscape(name)))
+		if info.IsDir() {
+			h.Set("Content-Type", "application/x-directory")
+			_, err := mw.CreatePart(h)
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		h.Set("Content-Type", "application/octet-stream")
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(part, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+func (t *Target) call(ctx context.Context, cmd string, params url.Values, contentType string, body io.Reader) (*http.Response, error) {
+	u := *t.api
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v0/" + cmd
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "ipfs: %s failed", cmd)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var msg struct{ Message string }
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&msg)
+		return nil, errors.Errorf("ipfs: %s failed: %s %s", cmd, resp.Status, msg.Message)
+	}
+	return resp, nil
+}
@@ -0,0 +1,190 @@
+package ipfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// fakeDaemon is a stand-in for the API of an IPFS daemon. The CID of an added directory is
+// a hash of the files below it, it keeps the recursive pins.
+type fakeDaemon struct {
+	mu    sync.Mutex
+	added []string // names of the last add
+	pins  map[string]bool
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch req.URL.Path {
+	case "/api/v0/add":
+		d.add(w, req)
+	case "/api/v0/pin/rm":
+		cid := req.URL.Query().Get("arg")
+		if !d.pins[cid] {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"Message": "not pinned or pinned indirectly"})
+			return
+		}
+		delete(d.pins, cid)
+		json.NewEncoder(w).Encode(map[string][]string{"Pins": {cid}})
+	case "/api/v0/id":
+		json.NewEncoder(w).Encode(map[string]string{"ID": "QmPeer", "AgentVersion": "fake/0.1"})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (d *fakeDaemon) add(w http.ResponseWriter, req *http.Request) {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var (
+		mr   = multipart.NewReader(req.Body, params["boundary"])
+		h    = sha256.New()
+		root string
+	)
+	d.added = nil
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, err := url.QueryUnescape(part.FileName())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if root == "" {
+			root = name
+		}
+		d.added = append(d.added, name)
+		fmt.Fprintf(h, "%s\n", name)
+		io.Copy(h, part)
+	}
+	cid := fmt.Sprintf("Qm%x", h.Sum(nil))
+	if req.URL.Query().Get("pin") == "true" {
+		d.pins[cid] = true
+	}
+	enc := json.NewEncoder(w)
+	for _, name := range d.added[1:] {
+		enc.Encode(map[string]string{"Name": name, "Hash": "QmFile"})
+	}
+	enc.Encode(map[string]string{"Name": root, "Hash": cid})
+}
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	gitDir := filepath.Join(dir, "app.git")
+	git := func(cwd string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = cwd
+		cmd.Env = append(os.Environ(),
+			"HOME="+dir,
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	commit := func(msg string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(work, "README"), []byte(msg+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git(work, "add", "README")
+		git(work, "commit", "--quiet", "-m", msg)
+	}
+	git(dir, "init", "--quiet", "--initial-branch=master", work)
+	commit("initial")
+	git(dir, "clone", "--quiet", "--bare", work, gitDir)
+
+	daemon := &fakeDaemon{pins: make(map[string]bool)}
+	srv := httptest.NewServer(daemon)
+	defer srv.Close()
+	target, err := New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx   = context.Background()
+		repo  = &models.Repository{Name: "app"}
+		state = &models.RepositoryTarget{}
+	)
+	publish := func() {
+		t.Helper()
+		if err := target.PublishObjects(ctx, repo, state, gitDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := target.PublishRefs(ctx, repo, state, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish()
+	first := state.IPFSCID
+	if first == "" || !daemon.pins[first] {
+		t.Fatalf("the root %q is not pinned, pins are %v", first, daemon.pins)
+	}
+	var haveRefs bool
+	for _, name := range daemon.added {
+		switch name {
+		case "app.git/config", "app.git/hooks", "app.git/description":
+			t.Errorf("%s was added", name)
+		case "app.git/info/refs":
+			haveRefs = true
+		}
+	}
+	if !haveRefs {
+		t.Errorf("info/refs for dumb clients is missing in %v", daemon.added)
+	}
+
+	// the next root replaces the pin of the old one
+	commit("second")
+	git(work, "push", "--quiet", gitDir, "master")
+	publish()
+	if state.IPFSCID == first {
+		t.Fatalf("the root didn't change after a commit")
+	}
+	if daemon.pins[first] || !daemon.pins[state.IPFSCID] || len(daemon.pins) != 1 {
+		t.Errorf("pins are %v, want only %s", daemon.pins, state.IPFSCID)
+	}
+
+	// a root that isn't pinned anymore doesn't fail the publish
+	delete(daemon.pins, state.IPFSCID)
+	commit("third")
+	git(work, "push", "--quiet", gitDir, "master")
+	publish()
+
+	status, err := target.Status(ctx)
+	if err != nil || !strings.Contains(status, "QmPeer") {
+		t.Errorf("status is %q, %v", status, err)
+	}
+}
@@ -0,0 +1,177 @@
+package ssb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// packet flags of the muxrpc packet-stream codec
+const (
+	flagStream = 0x08
+	flagEnd    = 0x04
+
+	typeBinary = 0x00
+	typeString = 0x01
+	typeJSON   = 0x02
+)
+
+// defaultTimeout bounds connections without a deadline on their context
+const defaultTimeout = 5 * time.Minute
+
+// client is a minimal muxrpc client for the unauthenticated local connection of an sbot (ssb-unix-socket with ssb-no-auth).
+// Calls are made one at a time.
+type client struct {
+	conn net.Conn
+	req  int32
+}
+
+type request struct {
+	Name []string      `json:"name"`
+	Args []interface{} `json:"args"`
+	Type string        `json:"type"`
+}
+
+// dial connects to addr, which is either unix:/path/to/socket or tcp:host:port
+func dial(ctx context.Context, addr string) (*client, error) {
+	network, address := "unix", addr
+	if i := strings.Index(addr, ":"); i > 0 {
+		network, address = addr[:i], strings.TrimPrefix(addr[i+1:], "//")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb: failed to connect to sbot")
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	conn.SetDeadline(deadline)
+	return &client{conn: conn}, nil
+}
+
+// Close says goodbye and closes the connection
+func (c *client) Close() error {
+	c.conn.Write(make([]byte, 9))
+	return c.conn.Close()
+}
+
+// async calls method and decodes the result into out
+func (c *client) async(method string, out interface{}, args ...interface{}) error {
+	c.req++
+	req := c.req
+	if err := c.writeJSON(0, req, request{Name: strings.Split(method, "."), Args: nonNil(args), Type: "async"}); err != nil {
+		return err
+	}
+	flags, body, err := c.readReply(req)
+	if err != nil {
+		return err
+	}
+	if flags&flagEnd != 0 {
+		return remoteError(method, body)
+	}
+	if out == nil {
+		return nil
+	}
+	if flags&0x03 != typeJSON {
+		// plain strings, like blob ids
+		body, _ = json.Marshal(string(body))
+	}
+	return errors.Wrapf(json.Unmarshal(body, out), "ssb: invalid reply to %s", method)
+}
+
+// sink calls method and streams r to it
+func (c *client) sink(method string, r io.Reader, args ...interface{}) error {
+	c.req++
+	req := c.req
+	if err := c.writeJSON(flagStream, req, request{Name: strings.Split(method, "."), Args: nonNil(args), Type: "sink"}); err != nil {
+		return err
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := c.write(flagStream|typeBinary, req, buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := c.write(flagStream|flagEnd|typeJSON, req, []byte("true")); err != nil {
+		return err
+	}
+	_, body, err := c.readReply(req)
+	if err != nil {
+		return err
+	}
+	if string(body) != "true" {
+		return remoteError(method, body)
+	}
+	return nil
+}
+
+func (c *client) writeJSON(flags byte, req int32, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(flags|typeJSON, req, body)
+}
+
+func (c *client) write(flags byte, req int32, body []byte) error {
+	var hdr [9]byte
+	hdr[0] = flags
+	binary.BigEndian.PutUint32(hdr[1:5], uint32(len(body)))
+	binary.BigEndian.PutUint32(hdr[5:9], uint32(req))
+	if _, err := c.conn.Write(append(hdr[:], body...)); err != nil {
+		return errors.Wrap(err, "ssb: write failed")
+	}
+	return nil
+}
+
+// readReply skips packets until the answer to req arrives
+func (c *client) readReply(req int32) (byte, []byte, error) {
+	for {
+		var hdr [9]byte
+		if _, err := io.ReadFull(c.conn, hdr[:]); err != nil {
+			return 0, nil, errors.Wrap(err, "ssb: read failed")
+		}
+		n := binary.BigEndian.Uint32(hdr[1:5])
+		if n > 1<<24 {
+			return 0, nil, errors.Errorf("ssb: packet too large (%d bytes)", n)
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			return 0, nil, errors.Wrap(err, "ssb: read failed")
+		}
+		if int32(binary.BigEndian.Uint32(hdr[5:9])) == -req {
+			return hdr[0], body, nil
+		}
+	}
+}
+
+func remoteError(method string, body []byte) error {
+	var e struct{ Message string }
+	if json.Unmarshal(body, &e) == nil && e.Message != "" {
+		return errors.Errorf("ssb: %s failed: %s", method, e.Message)
+	}
+	return errors.Errorf("ssb: %s failed: %s", method, body)
+}
+
+func nonNil(args []interface{}) []interface{} {
+	if args == nil {
+		return []interface{}{}
+	}
+	return args
+}
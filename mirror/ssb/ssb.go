@@ -0,0 +1,207 @@
+// Package ssb is a mirror target that publishes repositories to Secure Scuttlebutt the way git-ssb does:
+// every repository gets a git-repo message, fetches become git-update messages
+// and the packfiles they reference are added as blobs.
+package ssb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// DefaultAddress is the local socket of an sbot in the default location
+var DefaultAddress = "unix:" + filepath.Join(os.Getenv("HOME"), ".ssb", "socket")
+
+func init() {
+	mirror.RegisterTarget("ssb", func(t models.Target) (mirror.MirrorTarget, error) {
+		return New(t.Address), nil
+	})
+}
+
+// Target publishes to the sbot listening on Addr
+type Target struct {
+	Addr string
+
+	// blobs added by PublishObjects for the next git-update
+	packs, indexes []blobLink
+}
+
+type blobLink struct {
+	Link string `json:"link"`
+	Size int64  `json:"size"`
+}
+
+// New returns a target for the sbot at addr, see dial for the format
+func New(addr string) *Target {
+	if addr == "" {
+		addr = DefaultAddress
+	}
+	return &Target{Addr: addr}
+}
+
+// PublishObjects creates the git-repo message if needed and adds a pack with the objects
+// that weren't published to this sbot yet as a blob
+func (t *Target) PublishObjects(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, path string) error {
+	c, err := dial(ctx, t.Addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if state.SSBRepoID == "" {
+		var msg struct{ Key string }
+		content := map[string]interface{}{"type": "git-repo", "name": repo.Name}
+		if err := c.async("publish", &msg, content); err != nil {
+			return err
+		}
+		state.SSBRepoID = msg.Key
+	}
+
+	dir, err := ioutil.TempDir("", "synchrotron-ssb")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	pack, idx, err := packNew(ctx, state, path, dir)
+	if err != nil || pack == "" {
+		return err
+	}
+	for _, f := range []struct {
+		file  string
+		links *[]blobLink
+	}{{pack, &t.packs}, {idx, &t.indexes}} {
+		l, err := addBlob(c, f.file)
+		if err != nil {
+			return err
+		}
+		*f.links = append(*f.links, l)
+	}
+	return nil
+}
+
+// PublishRefs publishes a git-update message and keeps its key in state
+func (t *Target) PublishRefs(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, updates []models.RefUpdate) error {
+	if state.SSBRepoID == "" {
+		return errors.New("ssb: repository message was not published")
+	}
+	c, err := dial(ctx, t.Addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	refs := make(map[string]interface{}, len(updates))
+	for _, u := range updates {
+		if u.NewHash == "" {
+			refs[u.Ref] = nil // deleted
+		} else {
+			refs[u.Ref] = u.NewHash
+		}
+	}
+	content := map[string]interface{}{
+		"type": "git-update",
+		"repo": state.SSBRepoID,
+		"refs": refs,
+	}
+	if len(t.packs) > 0 {
+		content["packs"] = t.packs
+		content["indexes"] = t.indexes
+	}
+
+	var msg struct{ Key string }
+	if err := c.async("publish", &msg, content); err != nil {
+		return err
+	}
+	state.SSBKey = msg.Key
+	return nil
+}
+
+// Status asks the sbot for its identity
+func (t *Target) Status(ctx context.Context) (string, error) {
+	c, err := dial(ctx, t.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	var who struct{ ID string }
+	if err := c.async("whoami", &who); err != nil {
+		return "", err
+	}
+	return "connected as " + who.ID, nil
+}
+
+// packNew writes a pack with every object reachable from the refs at path that isn't reachable
+// from the refs the sbot of state got already. It returns empty paths if there is nothing new.
+func packNew(ctx context.Context, state *models.RepositoryTarget, path, dir string) (pack, idx string, err error) {
+	refs, err := mirror.ListRefs(ctx, path)
+	if err != nil {
+		return "", "", err
+	}
+
+	var revs bytes.Buffer
+	for _, r := range refs {
+		fmt.Fprintln(&revs, r.Hash)
+	}
+	for _, r := range mirror.ParseRefs(state.Refs) {
+		fmt.Fprintln(&revs, "^"+r.Hash)
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "pack-objects", "--revs", "--quiet", filepath.Join(dir, "pack"))
+	cmd.Dir = path
+	cmd.Stdin = &revs
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// objects of refs published earlier might be gone after a force push, the caller retries next time
+		return "", "", errors.Wrapf(err, "ssb: pack-objects failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	sum := strings.TrimSpace(out.String())
+	if sum == "" {
+		return "", "", nil
+	}
+	base := filepath.Join(dir, "pack-"+sum)
+	if fi, err := os.Stat(base + ".pack"); err != nil || fi.Size() <= 32 {
+		// header and checksum only
+		return "", "", err
+	}
+	return base + ".pack", base + ".idx", nil
+}
+
+// addBlob computes the ssb blob id of file and streams it to blobs.add
+func addBlob(c *client, file string) (blobLink, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return blobLink{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return blobLink{}, err
+	}
+	id := "&" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ".sha256"
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return blobLink{}, err
+	}
+	if err := c.sink("blobs.add", f, id); err != nil {
+		return blobLink{}, err
+	}
+	return blobLink{Link: id, Size: size}, nil
+}
@@ -0,0 +1,79 @@
+package ssb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+func TestPackNew(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	git := func(cwd string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = cwd
+		cmd.Env = append(os.Environ(),
+			"HOME="+dir,
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(msg string) string {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(work, msg), []byte(msg+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git(work, "add", msg)
+		git(work, "commit", "--quiet", "-m", msg)
+		return git(work, "rev-parse", "HEAD")
+	}
+	git(dir, "init", "--quiet", "--initial-branch=master", work)
+	first := commit("first")
+	second := commit("second")
+	gitDir := filepath.Join(dir, "app.git")
+	git(dir, "clone", "--quiet", "--bare", work, gitDir)
+
+	// objects lists the objects in the pack packNew writes for state
+	objects := func(state *models.RepositoryTarget) []string {
+		t.Helper()
+		out := t.TempDir()
+		_, idx, err := packNew(context.Background(), state, gitDir, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx == "" {
+			return nil
+		}
+		var list []string
+		for _, line := range strings.Split(git(dir, "verify-pack", "-v", idx), "\n") {
+			if f := strings.Fields(line); len(f) >= 3 && (f[1] == "commit" || f[1] == "tree" || f[1] == "blob") {
+				list = append(list, f[1])
+			}
+		}
+		return list
+	}
+
+	// what an sbot gets only depends on the refs it got itself
+	if got := objects(&models.RepositoryTarget{}); len(got) != 6 {
+		t.Errorf("a new sbot gets %v, want two commits with their trees and blobs", got)
+	}
+	if got := objects(&models.RepositoryTarget{Refs: first + " refs/heads/master\n"}); len(got) != 3 {
+		t.Errorf("an sbot with the first commit gets %v, want the second commit, its tree and blob", got)
+	}
+	if got := objects(&models.RepositoryTarget{Refs: second + " refs/heads/master\n"}); got != nil {
+		t.Errorf("an up to date sbot gets %v", got)
+	}
+}
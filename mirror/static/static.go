@@ -0,0 +1,249 @@
+// Package static exports mirrors as plain directories for git's dumb HTTP transport.
+//
+// Any static file server, IPFS gateway or rsync target can serve the export,
+// clients clone it with git clone https://example.com/<host>/<owner>/<name>.git
+package static
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+func init() {
+	mirror.RegisterTarget("static", func(t models.Target) (mirror.MirrorTarget, error) {
+		return New(t.Address), nil
+	})
+}
+
+// Target keeps an export of every linked repository below Dir
+type Target struct {
+	Dir string
+}
+
+// New returns a target exporting to dir, config.Config.Static.Dir if it's empty
+func New(dir string) *Target {
+	if dir == "" {
+		dir = config.Config.Static.Dir
+	}
+	return &Target{Dir: dir}
+}
+
+// Path returns where repo is exported to
+func (t *Target) Path(repo *models.Repository) string {
+	return filepath.Join(t.Dir, filepath.FromSlash(mirror.RelPath(repo)))
+}
+
+// PublishObjects copies the objects of the mirror at path that are missing in the export
+func (t *Target) PublishObjects(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, path string) error {
+	return exportObjects(ctx, path, t.Path(repo))
+}
+
+// PublishRefs replaces the refs and the info files of the export, after that clones see the updates
+func (t *Target) PublishRefs(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, updates []models.RefUpdate) error {
+	return exportRefs(mirror.Default.Path(repo), t.Path(repo))
+}
+
+// Status checks that the export directory is writable
+func (t *Target) Status(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return "", errors.Wrap(err, "static: export directory not usable")
+	}
+	f, err := ioutil.TempFile(t.Dir, ".status")
+	if err != nil {
+		return "", errors.Wrap(err, "static: export directory not writable")
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return "exporting to " + t.Dir, nil
+}
+
+// Export brings the export of the mirror at src in dst up to date
+func Export(ctx context.Context, src, dst string) error {
+	if err := exportObjects(ctx, src, dst); err != nil {
+		return err
+	}
+	return exportRefs(src, dst)
+}
+
+func exportObjects(ctx context.Context, src, dst string) error {
+	// dumb clients need info/refs and objects/info/packs
+	cmd := exec.CommandContext(ctx, "git", "update-server-info")
+	cmd.Dir = src
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "static: update-server-info failed: %s", out)
+	}
+
+	// objects never change, so existing ones are kept.
+	// Index files go last, a pack is only usable once its index is there.
+	var idx []string
+	err := walkFiles(filepath.Join(src, "objects"), func(rel string) error {
+		switch {
+		case strings.HasPrefix(rel, "info/"), strings.HasSuffix(rel, ".lock"), strings.Contains(rel, "tmp_"):
+			return nil
+		case strings.HasSuffix(rel, ".idx"):
+			idx = append(idx, rel)
+			return nil
+		}
+		return copyMissing(filepath.Join(src, "objects", rel), filepath.Join(dst, "objects", rel))
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range idx {
+		if err := copyMissing(filepath.Join(src, "objects", rel), filepath.Join(dst, "objects", rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refFiles are replaced on every export, HEAD goes last
+var refFiles = []string{"packed-refs", "info/refs", "objects/info/packs", "HEAD"}
+
+func exportRefs(src, dst string) error {
+	// loose refs, deleted ones are removed from the export too
+	if err := syncTree(filepath.Join(src, "refs"), filepath.Join(dst, "refs")); err != nil {
+		return err
+	}
+	for _, name := range refFiles {
+		err := copyFile(filepath.Join(src, name), filepath.Join(dst, name))
+		if os.IsNotExist(errors.Cause(err)) && name == "packed-refs" {
+			os.Remove(filepath.Join(dst, name))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// packs and loose objects that were dropped by a repack are no longer listed, it's safe to remove them now
+	return walkFiles(filepath.Join(dst, "objects"), func(rel string) error {
+		if strings.HasPrefix(rel, "info/") {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(src, "objects", rel)); os.IsNotExist(err) {
+			return os.Remove(filepath.Join(dst, "objects", rel))
+		}
+		return nil
+	})
+}
+
+// syncTree makes the files below dst match the ones below src
+func syncTree(src, dst string) error {
+	err := walkFiles(src, func(rel string) error {
+		return copyFile(filepath.Join(src, rel), filepath.Join(dst, rel))
+	})
+	if err != nil {
+		return err
+	}
+	return walkFiles(dst, func(rel string) error {
+		if _, err := os.Stat(filepath.Join(src, rel)); os.IsNotExist(err) {
+			return os.Remove(filepath.Join(dst, rel))
+		}
+		return nil
+	})
+}
+
+// walkFiles calls fn with the slash separated path of every regular file below root
+func walkFiles(root string, fn func(rel string) error) error {
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == root {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel))
+	})
+	return errors.Wrap(err, "static: export failed")
+}
+
+func copyMissing(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile replaces dst atomically with the contents of src
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "static: open failed")
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return errors.Wrap(err, "static: mkdir failed")
+	}
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".tmp-"+filepath.Base(dst))
+	if err != nil {
+		return errors.Wrap(err, "static: create failed")
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates 0600, the export is meant to be served
+		err = os.Chmod(out.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return errors.Wrap(err, "static: copy failed")
+	}
+	return nil
+}
+
+// ExportAll exports every public mirror in the store that isn't archived to dir, it's what -export-static runs.
+// Whoever serves dir can't check visibility, so internal and private repositories are left out.
+func ExportAll(ctx context.Context, tx *gorm.DB, dir string, log logging.Interface) error {
+	var repos []models.Repository
+	err := tx.Where("state IS NULL OR state <> ?", models.RepoArchived).
+		Where("visibility = ?", models.VisibilityPublic).
+		Find(&repos).Error
+	if err != nil {
+		return errors.Wrap(err, "static: loading repositories failed")
+	}
+	t := New(dir)
+	var failed int
+	for i := range repos {
+		repo := &repos[i]
+		if !mirror.Default.Exists(repo) {
+			log.Log("event", "skipped", "repo", repo.FullName, "reason", "not fetched yet")
+			continue
+		}
+		if err := Export(ctx, mirror.Default.Path(repo), t.Path(repo)); err != nil {
+			log.Log("event", "export failed", "repo", repo.FullName, "err", err)
+			failed++
+			continue
+		}
+		log.Log("event", "exported", "repo", repo.FullName, "path", t.Path(repo))
+	}
+	if failed > 0 {
+		return errors.Errorf("static: %d of %d repositories failed", failed, len(repos))
+	}
+	return nil
+}
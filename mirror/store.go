@@ -0,0 +1,69 @@
+// Package mirror keeps a bare git mirror on disk for every models.Repository.
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cryptix/synchrotron/config"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// Default is the store configured through config.Config.Mirror
+var Default *Store
+
+func init() {
+	Default = New(config.Config.Mirror.Dir)
+}
+
+// Store is a directory of bare repositories, one per upstream
+type Store struct {
+	Root string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// New returns a Store rooted at dir
+func New(dir string) *Store {
+	return &Store{
+		Root:  dir,
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+// Path returns the location of the bare mirror for repo
+func (s *Store) Path(repo *models.Repository) string {
+	return filepath.Join(s.Root, filepath.FromSlash(RelPath(repo)))
+}
+
+// Exists reports whether repo was cloned already
+func (s *Store) Exists(repo *models.Repository) bool {
+	_, err := os.Stat(filepath.Join(s.Path(repo), "HEAD"))
+	return err == nil
+}
+
+// lock serializes access to the mirror at path
+func (s *Store) lock(path string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[path]
+	if !ok {
+		l = new(sync.Mutex)
+		s.locks[path] = l
+	}
+	s.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// RelPath maps the upstream URL of repo to host/owner/name.git.
+// Local paths end up under local/ with the repository name.
+func RelPath(repo *models.Repository) string {
+	host, _ := repo.Upstream()
+	name := repo.DeriveFullName()
+	if host == "" {
+		return name + ".git"
+	}
+	return host + "/" + name + ".git"
+}
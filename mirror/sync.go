@@ -0,0 +1,142 @@
+package mirror
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+const (
+	headPrefix = "refs/heads/"
+	tagPrefix  = "refs/tags/"
+)
+
+// Sync fetches repo and stores the branch heads and tags it saw in repo.Heads and repo.Tags.
+// Every ref that moved is appended to the ref update history.
+func (s *Store) Sync(ctx context.Context, tx *gorm.DB, repo *models.Repository) (*Result, error) {
+	res, err := s.Fetch(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	// rows from before FullName existed
+	if name := repo.DeriveFullName(); repo.FullName != name {
+		repo.FullName = name
+		if err := tx.Model(repo).UpdateColumn("full_name", name).Error; err != nil {
+			return res, err
+		}
+	}
+	if err := saveHeads(tx, repo, res.Refs); err != nil {
+		return res, errors.Wrap(err, "mirror: failed to save heads")
+	}
+	if err := saveTags(tx, repo, res.Refs); err != nil {
+		return res, errors.Wrap(err, "mirror: failed to save tags")
+	}
+	if err := recordUpdates(ctx, tx, repo, res); err != nil {
+		return res, errors.Wrap(err, "mirror: failed to record ref updates")
+	}
+	return res, nil
+}
+
+func recordUpdates(ctx context.Context, tx *gorm.DB, repo *models.Repository, res *Result) error {
+	jobID := JobIDFromContext(ctx)
+	for _, u := range res.Updates {
+		entry := models.RefUpdate{
+			RepositoryID: repo.ID,
+			Ref:          u.Name,
+			OldHash:      u.Old,
+			NewHash:      u.New,
+			JobID:        jobID,
+		}
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+		res.History = append(res.History, entry)
+	}
+	return nil
+}
+
+func saveHeads(tx *gorm.DB, repo *models.Repository, refs []Ref) error {
+	var current []models.BranchHead
+	if err := tx.Where("repository_id = ?", repo.ID).Find(&current).Error; err != nil {
+		return err
+	}
+	known := make(map[string]models.BranchHead, len(current))
+	for _, h := range current {
+		known[h.Name] = h
+	}
+
+	var heads []models.BranchHead
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Name, headPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(r.Name, headPrefix)
+		h, ok := known[name]
+		delete(known, name)
+		if !ok {
+			h = models.BranchHead{RepositoryID: repo.ID, Name: name}
+		}
+		if !ok || h.Hash != r.Hash {
+			h.Hash = r.Hash
+			if err := tx.Save(&h).Error; err != nil {
+				return err
+			}
+		}
+		heads = append(heads, h)
+	}
+
+	for _, gone := range known {
+		if err := tx.Delete(&gone).Error; err != nil {
+			return err
+		}
+	}
+	repo.Heads = heads
+	return nil
+}
+
+func saveTags(tx *gorm.DB, repo *models.Repository, refs []Ref) error {
+	var current []models.Tag
+	if err := tx.Where("repository_id = ?", repo.ID).Find(&current).Error; err != nil {
+		return err
+	}
+	known := make(map[string]models.Tag, len(current))
+	for _, t := range current {
+		known[t.Name] = t
+	}
+
+	var tags []models.Tag
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Name, tagPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(r.Name, tagPrefix)
+		t, ok := known[name]
+		delete(known, name)
+		if !ok {
+			t = models.Tag{RepositoryID: repo.ID, Name: name}
+		}
+		target, annotated := r.Hash, r.Peeled != ""
+		if annotated {
+			target = r.Peeled
+		}
+		if !ok || t.Hash != r.Hash || t.Target != target || t.Annotated != annotated {
+			t.Hash, t.Target, t.Annotated = r.Hash, target, annotated
+			if err := tx.Save(&t).Error; err != nil {
+				return err
+			}
+		}
+		tags = append(tags, t)
+	}
+
+	for _, gone := range known {
+		if err := tx.Delete(&gone).Error; err != nil {
+			return err
+		}
+	}
+	repo.Tags = tags
+	return nil
+}
@@ -0,0 +1,177 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// MirrorTarget is a backend that repositories are pushed to after a successful fetch
+type MirrorTarget interface {
+	// PublishObjects copies the git objects of the mirror at path to the target.
+	// state is what the target got so far, implementations keep their own bookkeeping in it as well.
+	PublishObjects(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, path string) error
+
+	// PublishRefs announces how the refs moved since the last publish, after their objects were published
+	PublishRefs(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, updates []models.RefUpdate) error
+
+	// Status describes whether the target is reachable and working
+	Status(ctx context.Context) (string, error)
+}
+
+// TargetFactory creates a MirrorTarget for a configured target
+type TargetFactory func(t models.Target) (MirrorTarget, error)
+
+var (
+	targetsMu sync.Mutex
+	factories = make(map[string]TargetFactory)
+)
+
+// RegisterTarget makes a kind of target available, it is usually called from the init function of the implementation
+func RegisterTarget(kind string, f TargetFactory) {
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	if _, dup := factories[kind]; dup {
+		panic("mirror: target kind registered twice: " + kind)
+	}
+	factories[kind] = f
+}
+
+// TargetKinds lists the registered kinds of targets
+func TargetKinds() []string {
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	kinds := make([]string, 0, len(factories))
+	for k := range factories {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// OpenTarget returns the implementation for the configured target t
+func OpenTarget(t models.Target) (MirrorTarget, error) {
+	targetsMu.Lock()
+	f, ok := factories[t.Kind]
+	targetsMu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("mirror: unknown target kind %q", t.Kind)
+	}
+	return f(t)
+}
+
+// Publish brings every target linked to repo up to date with refs, the refs of its mirror.
+// Targets that are behind get the ref updates they missed, like ones that were linked
+// after the last fetch or failed before.
+func (s *Store) Publish(ctx context.Context, tx *gorm.DB, repo *models.Repository, refs []Ref) error {
+	var targets []models.Target
+	if err := tx.Model(repo).Related(&targets, "Targets").Error; err != nil {
+		return errors.Wrap(err, "mirror: failed to load targets")
+	}
+
+	var failed []string
+	for _, t := range targets {
+		if t.Disabled {
+			continue
+		}
+		if err := s.publishTo(ctx, tx, t, repo, refs); err != nil {
+			failed = append(failed, t.Name+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("mirror: publishing failed for %d of %d targets: %v", len(failed), len(targets), failed)
+	}
+	return nil
+}
+
+func (s *Store) publishTo(ctx context.Context, tx *gorm.DB, t models.Target, repo *models.Repository, refs []Ref) error {
+	state := models.RepositoryTarget{RepositoryID: repo.ID, TargetID: t.ID}
+	if err := tx.Where(&state).First(&state).Error; err != nil {
+		return errors.Wrap(err, "state")
+	}
+	var updates []models.RefUpdate
+	for _, u := range diffRefs(ParseRefs(state.Refs), refs) {
+		updates = append(updates, models.RefUpdate{RepositoryID: repo.ID, Ref: u.Name, OldHash: u.Old, NewHash: u.New})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	err := s.publishUpdates(ctx, t, repo, &state, updates)
+	if err != nil {
+		state.LastError = truncate(err.Error(), 1024)
+	} else {
+		now := time.Now()
+		state.Refs = formatRefs(refs)
+		state.PublishedAt = &now
+		state.LastError = ""
+	}
+	// the bookkeeping of the target is kept even if it failed halfway
+	if dbErr := saveState(tx, &state); dbErr != nil && err == nil {
+		err = errors.Wrap(dbErr, "state")
+	}
+	return err
+}
+
+// saveState updates every column of state. Unlike Save it doesn't bring back a link that was removed in the meantime.
+func saveState(tx *gorm.DB, state *models.RepositoryTarget) error {
+	cols := make(map[string]interface{})
+	for _, f := range tx.NewScope(state).Fields() {
+		if !f.IsPrimaryKey && !f.IsIgnored {
+			cols[f.DBName] = f.Field.Interface()
+		}
+	}
+	return tx.Model(state).UpdateColumns(cols).Error
+}
+
+func (s *Store) publishUpdates(ctx context.Context, t models.Target, repo *models.Repository, state *models.RepositoryTarget, updates []models.RefUpdate) error {
+	mt, err := OpenTarget(t)
+	if err != nil {
+		return err
+	}
+	if err := mt.PublishObjects(ctx, repo, state, s.Path(repo)); err != nil {
+		return errors.Wrap(err, "objects")
+	}
+	return errors.Wrap(mt.PublishRefs(ctx, repo, state, updates), "refs")
+}
+
+// formatRefs is the inverse of ParseRefs
+func formatRefs(refs []Ref) string {
+	var b strings.Builder
+	for _, r := range refs {
+		fmt.Fprintf(&b, "%s %s\n", r.Hash, r.Name)
+	}
+	return b.String()
+}
+
+// ParseRefs reads the "<hash> <ref>" lines of RepositoryTarget.Refs
+func ParseRefs(s string) []Ref {
+	var refs []Ref
+	for _, line := range strings.Split(s, "\n") {
+		if f := strings.Fields(line); len(f) == 2 {
+			refs = append(refs, Ref{Name: f[1], Hash: f[0]})
+		}
+	}
+	return refs
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func init() {
+	RegisterHook("targets", func(ctx context.Context, tx *gorm.DB, repo *models.Repository, res *Result) error {
+		return Default.Publish(ctx, tx, repo, res.Refs)
+	})
+}
@@ -0,0 +1,120 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/cryptix/synchrotron/models"
+)
+
+// fakeTarget records what Publish sends and fails while err is set
+type fakeTarget struct {
+	err     error
+	updates [][]models.RefUpdate
+}
+
+func (f *fakeTarget) PublishObjects(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, path string) error {
+	return f.err
+}
+
+func (f *fakeTarget) PublishRefs(ctx context.Context, repo *models.Repository, state *models.RepositoryTarget, updates []models.RefUpdate) error {
+	f.updates = append(f.updates, updates)
+	return nil
+}
+
+func (f *fakeTarget) Status(ctx context.Context) (string, error) {
+	return "fake", nil
+}
+
+var fake = new(fakeTarget)
+
+func init() {
+	RegisterTarget("fake", func(t models.Target) (MirrorTarget, error) {
+		return fake, nil
+	})
+}
+
+func TestPublishCatchesUp(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.AutoMigrate(&models.Repository{}, &models.Target{}, &models.RepositoryTarget{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	repo := models.Repository{Name: "app", URL: "https://example.com/acme/app.git"}
+	target := models.Target{Name: "fake", Kind: "fake"}
+	if err := db.Create(&repo).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&target).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx   = context.Background()
+		store = New(filepath.Join(dir, "mirrors"))
+		refs  = []Ref{{Name: "refs/heads/master", Hash: "1111"}, {Name: "refs/tags/v1", Hash: "2222"}}
+	)
+	publish := func() error {
+		t.Helper()
+		fake.updates = nil
+		return store.Publish(ctx, db, &repo, refs)
+	}
+	state := func() models.RepositoryTarget {
+		t.Helper()
+		var s models.RepositoryTarget
+		if err := db.Where(&models.RepositoryTarget{RepositoryID: repo.ID, TargetID: target.ID}).First(&s).Error; err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// linked after the mirror was synced, the target gets everything there is
+	if err := db.Model(&repo).Association("Targets").Append(target).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := publish(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.updates) != 1 || len(fake.updates[0]) != 2 || fake.updates[0][0].OldHash != "" {
+		t.Fatalf("a new target got %+v, want two created refs", fake.updates)
+	}
+
+	// up to date
+	if err := publish(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.updates) != 0 {
+		t.Fatalf("an up to date target got %+v", fake.updates)
+	}
+
+	// a failed publish is retried with the next one
+	refs[0].Hash = "3333"
+	fake.err = errors.New("offline")
+	if err := publish(); err == nil {
+		t.Fatal("the failed publish returned no error")
+	}
+	if s := state(); s.LastError == "" || s.Refs != "1111 refs/heads/master\n2222 refs/tags/v1\n" {
+		t.Fatalf("after a failure the state is %+v", s)
+	}
+	fake.err = nil
+	if err := publish(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.updates) != 1 || len(fake.updates[0]) != 1 {
+		t.Fatalf("the retry sent %+v, want one update", fake.updates)
+	}
+	if u := fake.updates[0][0]; u.Ref != "refs/heads/master" || u.OldHash != "1111" || u.NewHash != "3333" {
+		t.Errorf("the retry sent %+v", u)
+	}
+	if s := state(); s.LastError != "" || s.PublishedAt == nil {
+		t.Errorf("after the retry the state is %+v", s)
+	}
+}
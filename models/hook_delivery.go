@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// HookDelivery is an append-only record of a call to one of the incoming webhooks
+type HookDelivery struct {
+	ID           uint `gorm:"primary_key"`
+	CreatedAt    time.Time
+	Provider     string // github, gitlab or gitea
+	Event        string
+	DeliveryID   string
+	Repository   Repository
+	RepositoryID uint `gorm:"index"`
+	RemoteAddr   string
+	// StatusCode is what the sender got back, Result says why
+	StatusCode int
+	Result     string `sql:"size:1024"`
+	Payload    string `sql:"type:text"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// RefUpdate is an append-only record of a ref moving in a mirror.
+// OldHash is empty for new refs and NewHash is empty for deleted ones.
+type RefUpdate struct {
+	ID           uint `gorm:"primary_key"`
+	CreatedAt    time.Time
+	Repository   Repository
+	RepositoryID uint   `gorm:"index"`
+	Ref          string `gorm:"index"`
+	OldHash      string
+	NewHash      string
+	// JobID is the qor job that ran the fetch
+	JobID string
+}
@@ -1,15 +1,291 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/qor/transition"
+)
 
 type Repository struct {
 	gorm.Model
 	Name, URL string
 	Type      string
 	Heads     []BranchHead
+	Tags      []Tag
+	Targets   []Target `gorm:"many2many:repository_targets"`
+
+	// FullName is owner/name, derived from URL when saving
+	FullName   string `gorm:"index"`
+	Visibility string
+
+	// PollInterval is in minutes, zero uses config.Config.Poll.Interval
+	PollInterval  uint
+	LastFetchedAt *time.Time
+	NextPollAt    *time.Time
+
+	// consecutive failed fetches, reset by the next successful one
+	FailureCount uint
+	LastError    string `sql:"size:1024"`
+	NextRetryAt  *time.Time
+
+	// WebhookSecret signs the push events of the upstream, incoming webhooks are refused without it
+	WebhookSecret string
+
+	// DiscoveredByID is the repository that depends on this one, zero if it was added by hand
+	DiscoveredByID uint `gorm:"index"`
+	// DiscoveryDepth counts the dependency hops from a repository that was added by hand
+	DiscoveryDepth uint
+
+	transition.Transition
+}
+
+// Repository visibilities
+const (
+	// VisibilityPublic can be read by anyone, even without signing in
+	VisibilityPublic = "public"
+	// VisibilityInternal can be read by every signed in user
+	VisibilityInternal = "internal"
+	// VisibilityPrivate can only be read by Admins and Maintainers
+	VisibilityPrivate = "private"
+)
+
+var Visibilities = []string{VisibilityPublic, VisibilityInternal, VisibilityPrivate}
+
+// CanRead reports whether user may read repo, user is nil for anonymous access
+func (repo Repository) CanRead(user *User) bool {
+	switch repo.Visibility {
+	case VisibilityPublic:
+		return true
+	case VisibilityInternal, "":
+		return user != nil
+	case VisibilityPrivate:
+		return user != nil && (user.Role == "Admin" || user.Role == "Maintainer")
+	}
+	return false
+}
+
+// BeforeSave keeps FullName in line with URL
+func (repo *Repository) BeforeSave() {
+	repo.FullName = repo.DeriveFullName()
+}
+
+// Upstream splits URL into host and path. It understands regular URLs
+// and the scp-like user@host:path syntax, the host is empty for local paths.
+func (repo Repository) Upstream() (host, p string) {
+	raw := repo.URL
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname()), u.Path
+	}
+	if !strings.Contains(raw, "://") {
+		if i := strings.Index(raw, ":"); i > 0 && !strings.Contains(raw[:i], "/") {
+			host = raw[:i]
+			if at := strings.LastIndex(host, "@"); at >= 0 {
+				host = host[at+1:]
+			}
+			return strings.ToLower(host), raw[i+1:]
+		}
+	}
+	return "", ""
+}
+
+// PublicURL returns URL without credentials, it's empty for local paths
+func (repo Repository) PublicURL() string {
+	if host, _ := repo.Upstream(); host == "" {
+		return ""
+	}
+	if u, err := url.Parse(repo.URL); err == nil && u.Host != "" {
+		u.User = nil
+		return u.String()
+	}
+	// scp-like user@host:path
+	i := strings.Index(repo.URL, ":")
+	return repo.URL[strings.LastIndex(repo.URL[:i], "@")+1:]
+}
+
+// DeriveFullName returns owner/name for the upstream path, local repositories are owned by "local"
+func (repo Repository) DeriveFullName() string {
+	host, p := repo.Upstream()
+	if host == "" {
+		p = repo.Name
+		if p == "" {
+			p = path.Base(repo.URL)
+		}
+		p = "local/" + p
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	// no escaping the mirror store through the upstream path
+	var parts []string
+	for _, e := range strings.Split(p, "/") {
+		if e == "" || e == "." || e == ".." {
+			continue
+		}
+		parts = append(parts, e)
+	}
+	if len(parts) == 0 {
+		return "local/unnamed"
+	}
+	if len(parts) == 1 {
+		parts = append([]string{"local"}, parts...)
+	}
+	return strings.Join(parts, "/")
+}
+
+// QualifiedName is the full name with the upstream host in front, like github.com/owner/name.
+// It tells mirrors of different hosts with the same full name apart, local repositories keep local/name.
+func (repo Repository) QualifiedName() string {
+	name := repo.DeriveFullName()
+	if host, _ := repo.Upstream(); host != "" {
+		return host + "/" + name
+	}
+	return name
+}
+
+// FindRepositoryByName finds the repository with a QualifiedName like github.com/owner/name.
+// A plain owner/name works as well as long as only one host has it, it is nil otherwise.
+func FindRepositoryByName(tx *gorm.DB, name string) (*Repository, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return nil, nil
+	}
+	names := []string{name}
+	if i := strings.Index(name, "/"); i > 0 {
+		names = append(names, name[i+1:])
+	}
+	var repos []Repository
+	if err := tx.Where("full_name IN (?)", names).Order("id").Find(&repos).Error; err != nil {
+		return nil, err
+	}
+	var plain []*Repository
+	for i := range repos {
+		r := &repos[i]
+		if r.QualifiedName() == name {
+			return r, nil
+		}
+		if r.FullName == name {
+			plain = append(plain, r)
+		}
+	}
+	if len(plain) == 1 {
+		return plain[0], nil
+	}
+	return nil, nil
+}
+
+// FindRepositoryByPath finds the mirror of an import path like github.com/owner/name/sub.
+// It returns the repository with the longest matching full name and the part of p it covers.
+func FindRepositoryByPath(tx *gorm.DB, p string) (*Repository, string, error) {
+	elems := strings.Split(p, "/")
+	if len(elems) < 3 {
+		return nil, "", nil
+	}
+	for _, e := range elems {
+		if e == "" || e == "." || e == ".." {
+			return nil, "", nil
+		}
+	}
+	host := elems[0]
+
+	var names []string
+	for i := 3; i <= len(elems); i++ {
+		names = append(names, strings.Join(elems[1:i], "/"))
+	}
+	var repos []Repository
+	if err := tx.Where("full_name IN (?)", names).Find(&repos).Error; err != nil {
+		return nil, "", err
+	}
+	var found *Repository
+	for i := range repos {
+		r := &repos[i]
+		if rh, _ := r.Upstream(); rh != host {
+			continue
+		}
+		if found == nil || len(r.FullName) > len(found.FullName) {
+			found = r
+		}
+	}
+	if found == nil {
+		return nil, "", nil
+	}
+	return found, host + "/" + found.FullName, nil
+}
+
+// FindRepositoryByURL finds the mirror of an upstream URL, in any of the notations Upstream understands
+func FindRepositoryByURL(tx *gorm.DB, rawURL string) (*Repository, error) {
+	want := Repository{URL: rawURL}
+	host, _ := want.Upstream()
+	var repos []Repository
+	if err := tx.Where("full_name = ?", want.DeriveFullName()).Find(&repos).Error; err != nil {
+		return nil, err
+	}
+	for i := range repos {
+		if h, _ := repos[i].Upstream(); h == host {
+			return &repos[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Repository states
+const (
+	RepoPending  = "pending"
+	RepoCloning  = "cloning"
+	RepoFetching = "fetching"
+	RepoSynced   = "synced"
+	RepoStale    = "stale"
+	RepoFailing  = "failing"
+	RepoArchived = "archived"
+)
+
+var RepoStates = []string{RepoPending, RepoCloning, RepoFetching, RepoSynced, RepoStale, RepoFailing, RepoArchived}
+
+// RepositoryState is the lifecycle of a mirrored repository
+var RepositoryState = transition.New(&Repository{})
+
+func init() {
+	RepositoryState.Initial(RepoPending)
+	for _, s := range RepoStates {
+		RepositoryState.State(s)
+	}
+
+	// a fetch that was interrupted by a restart can start over
+	started := RepositoryState.Event("fetch_started")
+	started.To(RepoCloning).From(RepoPending, RepoCloning)
+	started.To(RepoFetching).From(RepoSynced, RepoStale, RepoFailing, RepoFetching)
+
+	RepositoryState.Event("fetch_succeeded").To(RepoSynced).From(RepoCloning, RepoFetching)
+	RepositoryState.Event("fetch_failed").To(RepoFailing).From(RepoCloning, RepoFetching)
+
+	// the poll interval ran out
+	RepositoryState.Event("mark_stale").To(RepoStale).From(RepoSynced)
+
+	RepositoryState.Event("archive").To(RepoArchived).From(RepoPending, RepoCloning, RepoFetching, RepoSynced, RepoStale, RepoFailing)
+	RepositoryState.Event("unarchive").To(RepoPending).From(RepoArchived)
+}
+
+// TriggerState runs event on the state machine of repo and saves the new state
+func (repo *Repository) TriggerState(tx *gorm.DB, event string, notes ...string) error {
+	if err := RepositoryState.Trigger(event, repo, tx, notes...); err != nil {
+		return err
+	}
+	return tx.Model(repo).UpdateColumn("state", repo.State).Error
 }
 
 type BranchHead struct {
 	gorm.Model
-	Name, Hash string
+	RepositoryID uint
+	Name, Hash   string
+}
+
+type Tag struct {
+	gorm.Model
+	RepositoryID uint
+	Name, Hash   string
+
+	// Target is the commit (or other object) an annotated tag points to, the same as Hash for lightweight tags
+	Target    string
+	Annotated bool
 }
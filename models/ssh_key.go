@@ -0,0 +1,34 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHKey is a public key a user can fetch mirrors over SSH with
+type SSHKey struct {
+	gorm.Model
+	User   User
+	UserID uint `gorm:"index"`
+	Title  string
+	// Key is in authorized_keys format
+	Key         string `sql:"size:4096"`
+	Fingerprint string `gorm:"unique_index"`
+}
+
+// BeforeSave checks the key, normalizes it and fills in the fingerprint
+func (k *SSHKey) BeforeSave() error {
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(k.Key)))
+	if err != nil {
+		return errors.Wrap(err, "invalid public key")
+	}
+	k.Key = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	k.Fingerprint = ssh.FingerprintSHA256(pub)
+	if k.Title == "" {
+		k.Title = comment
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Target is a configured mirror backend, see mirror.RegisterTarget for the available kinds
+type Target struct {
+	gorm.Model
+	Name string
+	Kind string
+	// Address is interpreted by the kind, like an API URL or a directory
+	Address  string
+	Options  string `sql:"size:1024"`
+	Disabled bool
+}
+
+// RepositoryTarget is the link between a repository and a target in Repository.Targets.
+// It keeps what the target got, so a target that is behind catches up with the next fetch.
+type RepositoryTarget struct {
+	RepositoryID uint `gorm:"primary_key;auto_increment:false"`
+	TargetID     uint `gorm:"primary_key;auto_increment:false"`
+
+	// Refs are the "<hash> <ref>" lines of the last successful publish
+	Refs        string `sql:"type:text"`
+	PublishedAt *time.Time
+	LastError   string `sql:"size:1024"`
+
+	// IPFSCID is the root of the last copy added to an ipfs target
+	IPFSCID string `gorm:"column:ipfs_cid"`
+	// SSBRepoID is the key of the git-repo message on an ssb target
+	SSBRepoID string `gorm:"column:ssb_repo_id"`
+	// SSBKey is the last git-update message
+	SSBKey string `gorm:"column:ssb_key"`
+}
+
+// TableName is the join table of Repository.Targets
+func (RepositoryTarget) TableName() string {
+	return "repository_targets"
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"net/http"
+
 	"github.com/jinzhu/gorm"
 	"github.com/qor/media"
 	"github.com/qor/media/oss"
@@ -18,6 +20,18 @@ type User struct {
 func (user User) DisplayName() string        { return user.Email }
 func (user User) AvailableLocales() []string { return []string{"de-DE", "en-US", "zh-CN"} }
 
+// RequestUser returns who sent req, HTTP basic auth is checked with basicAuth and wins over the session of currentUser.
+// Either may be nil, the result is nil for anonymous requests.
+func RequestUser(req *http.Request, basicAuth func(login, password string) *User, currentUser func(req *http.Request) *User) *User {
+	if login, password, ok := req.BasicAuth(); ok && basicAuth != nil {
+		return basicAuth(login, password)
+	}
+	if currentUser != nil {
+		return currentUser(req)
+	}
+	return nil
+}
+
 type AvatarImageStorage struct{ oss.OSS }
 
 func (AvatarImageStorage) GetSizes() map[string]*media.Size {
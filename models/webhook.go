@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Webhook is a subscriber that gets a signed POST when refs of mirrored repositories move
+type Webhook struct {
+	gorm.Model
+	Name   string
+	URL    string
+	Secret string
+	// Repositories limits the hook to these, it's called for every public repository if empty
+	Repositories []Repository `gorm:"many2many:webhook_repositories"`
+	// Events is a comma separated list of WebhookEvents, every event is sent if empty.
+	// branch and tag match all events of that kind.
+	Events   string
+	Disabled bool
+}
+
+// WebhookEvents are the kinds of ref changes a Webhook can subscribe to
+var WebhookEvents = []string{
+	"branch.created", "branch.updated", "branch.deleted",
+	"tag.created", "tag.updated", "tag.deleted",
+}
+
+// Wants reports whether the hook subscribed to event
+func (hook Webhook) Wants(event string) bool {
+	if strings.TrimSpace(hook.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(hook.Events, ",") {
+		e = strings.TrimSpace(e)
+		if e == event || strings.HasPrefix(event, e+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one POST to a Webhook, it's retried until it succeeds or runs out of attempts
+type WebhookDelivery struct {
+	gorm.Model
+	Webhook      Webhook
+	WebhookID    uint `gorm:"index"`
+	Repository   Repository
+	RepositoryID uint   `gorm:"index"`
+	Payload      string `sql:"type:text"`
+
+	State         string `gorm:"index"`
+	Attempts      uint
+	NextAttemptAt *time.Time `gorm:"index"`
+	DeliveredAt   *time.Time
+	// StatusCode and LastError are from the last attempt
+	StatusCode int
+	LastError  string `sql:"size:1024"`
+}
+
+// WebhookDelivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// DeliveryStates lists the states for filters
+var DeliveryStates = []string{DeliveryPending, DeliveryDelivered, DeliveryFailed}
@@ -0,0 +1,285 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/cryptix/go/backoff"
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/mirror"
+	"github.com/cryptix/synchrotron/models"
+)
+
+// Payload is the body of outgoing webhooks
+type Payload struct {
+	Repository PayloadRepository `json:"repository"`
+	Changes    []Change          `json:"changes"`
+	// JobID is the fetch that saw the changes
+	JobID string `json:"job_id,omitempty"`
+}
+
+// PayloadRepository identifies the mirror in a Payload
+type PayloadRepository struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	URL      string `json:"url"` // upstream, without credentials
+}
+
+// Change is one ref that moved, Before is empty for new refs and After for deleted ones
+type Change struct {
+	Event  string `json:"event"`
+	Ref    string `json:"ref"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// changeEvent names the WebhookEvent of u, ok is false for refs that aren't branches or tags
+func changeEvent(u mirror.RefUpdate) (string, bool) {
+	var kind string
+	switch {
+	case strings.HasPrefix(u.Name, "refs/heads/"):
+		kind = "branch"
+	case strings.HasPrefix(u.Name, "refs/tags/"):
+		kind = "tag"
+	default:
+		return "", false
+	}
+	switch {
+	case u.Old == "":
+		return kind + ".created", true
+	case u.New == "":
+		return kind + ".deleted", true
+	}
+	return kind + ".updated", true
+}
+
+// Queue adds a pending delivery for every webhook that subscribed to some of updates.
+// It returns how many were added.
+func Queue(tx *gorm.DB, repo *models.Repository, updates []mirror.RefUpdate, jobID string) (int, error) {
+	if len(updates) == 0 {
+		return 0, nil
+	}
+	var hooks []models.Webhook
+	if err := tx.Preload("Repositories").Where("disabled = ?", false).Find(&hooks).Error; err != nil {
+		return 0, errors.Wrap(err, "webhooks: loading subscribers failed")
+	}
+
+	now := time.Now()
+	n := 0
+	for _, hook := range hooks {
+		if !subscribed(hook, repo) {
+			continue
+		}
+		p := Payload{
+			Repository: PayloadRepository{ID: repo.ID, Name: repo.Name, FullName: repo.FullName, URL: repo.PublicURL()},
+			JobID:      jobID,
+		}
+		for _, u := range updates {
+			if ev, ok := changeEvent(u); ok && hook.Wants(ev) {
+				p.Changes = append(p.Changes, Change{Event: ev, Ref: u.Name, Before: u.Old, After: u.New})
+			}
+		}
+		if len(p.Changes) == 0 {
+			continue
+		}
+		body, err := json.Marshal(p)
+		if err != nil {
+			return n, err
+		}
+		d := models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			RepositoryID:  repo.ID,
+			Payload:       string(body),
+			State:         models.DeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := tx.Create(&d).Error; err != nil {
+			return n, errors.Wrap(err, "webhooks: adding delivery failed")
+		}
+		n++
+	}
+	return n, nil
+}
+
+// subscribed reports whether hook wants to hear about repo, hooks for every repository only get public ones
+func subscribed(hook models.Webhook, repo *models.Repository) bool {
+	if len(hook.Repositories) == 0 {
+		return repo.Visibility == models.VisibilityPublic
+	}
+	for _, r := range hook.Repositories {
+		if r.ID == repo.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultTick is used by Run when Sender.Tick isn't positive
+const DefaultTick = 10 * time.Second
+
+// Sender posts pending deliveries and retries the failed ones
+type Sender struct {
+	DB     *gorm.DB
+	Log    logging.Interface
+	Client *http.Client
+
+	// MaxAttempts is how often a delivery is tried before it's marked as failed
+	MaxAttempts uint
+	// Backoff is the wait after the nth failed attempt
+	Backoff backoff.Backoff
+	// Tick is how often due retries are looked for, DefaultTick if it is 0
+	Tick time.Duration
+
+	wake chan struct{}
+}
+
+// NewSender returns a Sender that still needs a DB and a Log
+func NewSender(maxAttempts uint, b backoff.Backoff, tick, timeout time.Duration) *Sender {
+	return &Sender{
+		Client:      &http.Client{Timeout: timeout},
+		MaxAttempts: maxAttempts,
+		Backoff:     b,
+		Tick:        tick,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Wake makes Run look for pending deliveries right away
+func (s *Sender) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is canceled
+func (s *Sender) Run(ctx context.Context) {
+	every := s.Tick
+	if every <= 0 {
+		every = DefaultTick
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		if err := s.sendDue(ctx); err != nil {
+			s.Log.Log("event", "sending failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Sender) sendDue(ctx context.Context) error {
+	var due []models.WebhookDelivery
+	err := s.DB.Preload("Webhook").
+		Where("state = ? AND next_attempt_at <= ?", models.DeliveryPending, time.Now()).
+		Order("id").Find(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.attempt(ctx, d)
+	}
+	return nil
+}
+
+// attempt posts d once and stores the outcome
+func (s *Sender) attempt(ctx context.Context, d models.WebhookDelivery) {
+	log := kitlog.With(s.Log, "delivery", d.ID, "webhook", d.Webhook.Name)
+	code, err := s.post(ctx, d)
+
+	now := time.Now()
+	cols := map[string]interface{}{
+		"attempts":    d.Attempts + 1,
+		"status_code": code,
+		"last_error":  "",
+	}
+	switch {
+	case err == nil:
+		cols["state"] = models.DeliveryDelivered
+		cols["delivered_at"] = now
+		cols["next_attempt_at"] = nil
+		log.Log("event", "delivered", "status", code)
+	case d.Attempts+1 >= s.MaxAttempts:
+		cols["state"] = models.DeliveryFailed
+		cols["next_attempt_at"] = nil
+		cols["last_error"] = truncate(err.Error(), 1024)
+		log.Log("event", "giving up", "attempts", d.Attempts+1, "err", err)
+	default:
+		next := now.Add(s.Backoff.Duration(int(d.Attempts)))
+		cols["next_attempt_at"] = next
+		cols["last_error"] = truncate(err.Error(), 1024)
+		log.Log("event", "attempt failed", "retry", next.Format(time.RFC3339), "err", err)
+	}
+	if err := s.DB.Model(&d).UpdateColumns(cols).Error; err != nil {
+		log.Log("event", "saving delivery failed", "err", err)
+	}
+}
+
+// post sends the payload of d, signed with the secret of its webhook like GitHub does
+func (s *Sender) post(ctx context.Context, d models.WebhookDelivery) (int, error) {
+	if d.Webhook.ID == 0 {
+		return 0, errors.New("webhook was deleted")
+	}
+	req, err := http.NewRequest(http.MethodPost, d.Webhook.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "synchrotron-webhook")
+	req.Header.Set("X-Synchrotron-Event", "refs")
+	req.Header.Set("X-Synchrotron-Delivery", fmt.Sprint(d.ID))
+	if d.Webhook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.Webhook.Secret))
+		mac.Write([]byte(d.Payload))
+		req.Header.Set("X-Synchrotron-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.Errorf("subscriber answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Redeliver queues d again with fresh attempts
+func Redeliver(tx *gorm.DB, d *models.WebhookDelivery) error {
+	return tx.Model(d).UpdateColumns(map[string]interface{}{
+		"state":           models.DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
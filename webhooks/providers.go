@@ -0,0 +1,135 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var providers = map[string]provider{
+	"github": {parse: parseGitHub, verify: verifyGitHub},
+	"gitlab": {parse: parseGitLab, verify: verifyGitLab},
+	"gitea":  {parse: parseGitea, verify: verifyGitea},
+}
+
+// repoURLs is the repository object GitHub and Gitea send with every event
+type repoURLs struct {
+	CloneURL string `json:"clone_url"`
+	SSHURL   string `json:"ssh_url"`
+	HTMLURL  string `json:"html_url"`
+}
+
+func (r repoURLs) list() []string {
+	return nonEmpty(r.CloneURL, r.SSHURL, r.HTMLURL)
+}
+
+func parseGitHub(req *http.Request, body []byte) (event, error) {
+	ev := event{
+		name:     req.Header.Get("X-GitHub-Event"),
+		delivery: req.Header.Get("X-GitHub-Delivery"),
+	}
+	ev.push = ev.name == "push"
+	var payload struct {
+		Repository repoURLs `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ev, errors.Wrap(err, "invalid payload")
+	}
+	ev.urls = payload.Repository.list()
+	if len(ev.urls) == 0 {
+		return ev, errors.New("payload has no repository")
+	}
+	return ev, nil
+}
+
+// verifyGitHub checks the HMAC of the body, SHA-256 if it was sent and SHA-1 otherwise
+func verifyGitHub(req *http.Request, body []byte, secret string) bool {
+	if sig := req.Header.Get("X-Hub-Signature-256"); sig != "" {
+		return strings.HasPrefix(sig, "sha256=") && checkMAC(sha256.New, body, secret, sig[len("sha256="):])
+	}
+	sig := req.Header.Get("X-Hub-Signature")
+	return strings.HasPrefix(sig, "sha1=") && checkMAC(sha1.New, body, secret, sig[len("sha1="):])
+}
+
+func parseGitea(req *http.Request, body []byte) (event, error) {
+	ev := event{
+		name:     req.Header.Get("X-Gitea-Event"),
+		delivery: req.Header.Get("X-Gitea-Delivery"),
+	}
+	switch ev.name {
+	case "push", "create", "delete":
+		ev.push = true
+	}
+	var payload struct {
+		Repository repoURLs `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ev, errors.Wrap(err, "invalid payload")
+	}
+	ev.urls = payload.Repository.list()
+	if len(ev.urls) == 0 {
+		return ev, errors.New("payload has no repository")
+	}
+	return ev, nil
+}
+
+func verifyGitea(req *http.Request, body []byte, secret string) bool {
+	return checkMAC(sha256.New, body, secret, req.Header.Get("X-Gitea-Signature"))
+}
+
+func parseGitLab(req *http.Request, body []byte) (event, error) {
+	ev := event{
+		name:     req.Header.Get("X-Gitlab-Event"),
+		delivery: req.Header.Get("X-Gitlab-Event-UUID"),
+	}
+	var payload struct {
+		ObjectKind string `json:"object_kind"`
+		Project    struct {
+			HTTPURL string `json:"git_http_url"`
+			SSHURL  string `json:"git_ssh_url"`
+			WebURL  string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ev, errors.Wrap(err, "invalid payload")
+	}
+	ev.push = payload.ObjectKind == "push" || payload.ObjectKind == "tag_push"
+	ev.urls = nonEmpty(payload.Project.HTTPURL, payload.Project.SSHURL, payload.Project.WebURL)
+	if len(ev.urls) == 0 {
+		return ev, errors.New("payload has no project")
+	}
+	return ev, nil
+}
+
+// verifyGitLab compares the secret token, GitLab doesn't sign the body
+func verifyGitLab(req *http.Request, body []byte, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+}
+
+func checkMAC(h func() hash.Hash, body []byte, secret, sig string) bool {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func nonEmpty(ss ...string) []string {
+	var out []string
+	for _, s := range ss {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
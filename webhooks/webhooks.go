@@ -0,0 +1,139 @@
+// Package webhooks receives the push events of GitHub, GitLab and Gitea,
+// so a mirror is fetched right away instead of at its next poll.
+//
+// Every repository has its own secret, deliveries are recorded as models.HookDelivery,
+// with their payload once the signature is verified.
+package webhooks
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jinzhu/gorm"
+
+	"github.com/cryptix/go/logging"
+	"github.com/cryptix/synchrotron/models"
+)
+
+const (
+	// maxPayload is more than any push event needs
+	maxPayload = 5 << 20
+	// maxRecorded is how much of a verified payload is kept in the delivery log
+	maxRecorded = 64 << 10
+
+	// unverified answers deliveries for unknown repositories and with a wrong signature alike,
+	// so anonymous senders can't tell which repositories are mirrored
+	unverified = "unknown repository or invalid signature"
+)
+
+// Handler answers /<prefix>/github, /<prefix>/gitlab and /<prefix>/gitea
+type Handler struct {
+	DB  *gorm.DB
+	Log logging.Interface
+
+	// Prefix is stripped from request paths, like /hooks/
+	Prefix string
+
+	// Enqueue starts a fetch of the repository with id
+	Enqueue func(id uint) error
+}
+
+// event is what a provider tells about a delivery
+type event struct {
+	name, delivery string
+	// push is set for events that move refs
+	push bool
+	// urls are the ways the upstream names the repository
+	urls []string
+}
+
+type provider struct {
+	parse  func(req *http.Request, body []byte) (event, error)
+	verify func(req *http.Request, body []byte, secret string) bool
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, h.Prefix), "/")
+	p, ok := providers[name]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxPayload+1))
+	if err != nil {
+		http.Error(w, "reading payload failed", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxPayload {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	d := models.HookDelivery{
+		Provider:   name,
+		RemoteAddr: req.RemoteAddr,
+	}
+	d.StatusCode, d.Result = h.handle(p, req, body, &d)
+
+	log := kitlog.With(h.Log, "provider", name, "event", d.Event, "delivery", d.DeliveryID)
+	if err := h.DB.Create(&d).Error; err != nil {
+		log.Log("msg", "recording delivery failed", "err", err)
+	}
+	log.Log("status", d.StatusCode, "result", d.Result)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(d.StatusCode)
+	fmt.Fprintln(w, d.Result)
+}
+
+// handle checks the delivery and enqueues the fetch, it returns the response for the sender.
+// The payload is only recorded once the signature checked out, anyone can post here.
+func (h Handler) handle(p provider, req *http.Request, body []byte, d *models.HookDelivery) (int, string) {
+	ev, err := p.parse(req, body)
+	d.Event, d.DeliveryID = ev.name, ev.delivery
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	var repo *models.Repository
+	for _, u := range ev.urls {
+		if repo, err = models.FindRepositoryByURL(h.DB, u); err != nil {
+			return http.StatusInternalServerError, "looking up repository failed"
+		}
+		if repo != nil {
+			break
+		}
+	}
+	if repo == nil {
+		h.Log.Log("event", ev.name, "delivery", ev.delivery, "msg", "no mirror", "urls", strings.Join(ev.urls, ", "))
+		return http.StatusUnauthorized, unverified
+	}
+	d.RepositoryID = repo.ID
+
+	// without a secret nothing is trusted, it looks like a wrong one to the sender
+	if repo.WebhookSecret == "" || !p.verify(req, body, repo.WebhookSecret) {
+		return http.StatusUnauthorized, unverified
+	}
+	d.Payload = string(body)
+	if len(d.Payload) > maxRecorded {
+		d.Payload = d.Payload[:maxRecorded]
+	}
+	if !ev.push {
+		return http.StatusOK, "ignored " + ev.name + " event"
+	}
+	if repo.State == models.RepoArchived {
+		return http.StatusOK, repo.FullName + " is archived"
+	}
+	if err := h.Enqueue(repo.ID); err != nil {
+		return http.StatusInternalServerError, "enqueueing fetch failed"
+	}
+	return http.StatusAccepted, "fetch of " + repo.FullName + " enqueued"
+}